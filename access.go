@@ -0,0 +1,272 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrAccessDenied is the error response of the request rejected by the auth rules
+var ErrAccessDenied = errors.New("access denied")
+
+const accessNameKey = "_access_name_"
+
+// DefaultAPIKeyHeader is the header of the API key if not configured
+const DefaultAPIKeyHeader = "X-API-Key"
+
+type apiKey struct {
+	name string
+	hash []byte
+}
+
+// accessRule protect a path prefix with basic auth and/or API keys
+type accessRule struct {
+	prefix string
+	realm  string
+	header string
+	users  map[string][]byte
+	keys   []apiKey
+}
+
+type accessRules struct {
+	rules []*accessRule
+}
+
+// dummyPasswordHash is compared for the unknown users, so the response time
+// doesn't tell if the user exist
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("gintool dummy password"), bcrypt.MinCost)
+
+func (r *accessRule) match(path string) bool {
+	prefix := strings.TrimSuffix(r.prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/") || prefix == ""
+}
+
+// match return the rule of the longest prefix matching the path, nil if none
+func (rules *accessRules) match(path string) *accessRule {
+	var found *accessRule
+	for _, r := range rules.rules {
+		if r.match(path) && (found == nil || len(strings.TrimSuffix(r.prefix, "/")) > len(strings.TrimSuffix(found.prefix, "/"))) {
+			found = r
+		}
+	}
+	return found
+}
+
+// check return the name of the user or the API key, false if not allowed
+func (r *accessRule) check(c *gin.Context) (string, bool) {
+	if len(r.keys) > 0 {
+		if key := c.GetHeader(r.header); key != "" {
+			sum := sha256.Sum256([]byte(key))
+			name, found := "", 0
+			for _, k := range r.keys {
+				if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+					name, found = k.name, 1
+				}
+			}
+			return name, found == 1
+		}
+	}
+	if r.users != nil {
+		if user, password, ok := c.Request.BasicAuth(); ok {
+			hash, found := r.users[user]
+			if !found {
+				hash = dummyPasswordHash
+			}
+			ok := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+			return user, ok && found
+		}
+	}
+	return "", false
+}
+
+// UseAccessRules is a middleware which check the auth rules of gin.conf,
+// every rule protect a path prefix with basic auth of a htpasswd file or the
+// API keys of a header. Only the rule of the longest prefix matching the path
+// is checked, whatever the order of the rules. The rejected requests get 401
+// and are logged.
+func UseAccessRules(config *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rules := config.access.Load()
+		if rules == nil {
+			c.Next()
+			return
+		}
+		if r := rules.match(c.Request.URL.Path); r != nil {
+			name, ok := r.check(c)
+			if !ok {
+				config.stdlog.Warn().
+					Str("request_id", RequestID(c)).
+					Str("ip", c.ClientIP()).
+					Str("method", c.Request.Method).
+					Str("path", c.Request.URL.Path).
+					Str("rule", r.prefix).
+					Str("name", name).
+					Msg("[Auth] access denied")
+				if r.users != nil {
+					c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", r.realm))
+				}
+				c.Set(configKey, config)
+				ErrorResponse(c, http.StatusUnauthorized, ErrAccessDenied)
+				return
+			}
+			c.Set(accessNameKey, name)
+		}
+		c.Next()
+	}
+}
+
+// AccessName return the user or the API key name allowed by the auth rules
+func AccessName(c *gin.Context) string {
+	return c.GetString(accessNameKey)
+}
+
+// Reload re-read the auth rules of the config file and their htpasswd and
+// API key files, the other settings need a restart
+func (ge *GinEngine) Reload() error {
+	m, err := readFile(ge.path)
+	if err != nil {
+		return err
+	}
+	var rules *accessRules
+	if mm, err := extract(m, "auth", "rules"); err == nil {
+		if rules, err = parseAccessRules(mm); err != nil {
+			return err
+		}
+	}
+	ge.config.access.Store(rules)
+	ge.config.stdlog.Info().Msgf("| reload %s", ge.path)
+	return nil
+}
+
+// parseAccessRules read the list of rules:
+//
+//   - prefix: /admin
+//     realm: Admin
+//     htpasswd: config/admin.htpasswd
+//   - prefix: /metrics
+//     header: X-API-Key
+//     apikeys: config/metrics.keys
+func parseAccessRules(m interface{}) (*accessRules, error) {
+	list, ok := m.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("wrong type auth rules")
+	}
+	rules := &accessRules{}
+	for _, item := range list {
+		r := &accessRule{realm: "Restricted", header: DefaultAPIKeyHeader}
+		values := map[string]string{}
+		for _, name := range []string{"prefix", "realm", "header", "htpasswd", "apikeys"} {
+			mm, err := extract(item, name)
+			if err != nil {
+				continue
+			}
+			s, ok := mm.(string)
+			if !ok || s == "" {
+				return nil, fmt.Errorf("wrong type auth rule %s", name)
+			}
+			values[name] = s
+		}
+		if values["prefix"] == "" {
+			return nil, fmt.Errorf("auth rule prefix is required")
+		}
+		r.prefix = values["prefix"]
+		if values["realm"] != "" {
+			r.realm = values["realm"]
+		}
+		if values["header"] != "" {
+			r.header = values["header"]
+		}
+		if values["htpasswd"] == "" && values["apikeys"] == "" {
+			return nil, fmt.Errorf("auth rule %s need htpasswd or apikeys", r.prefix)
+		}
+		if values["htpasswd"] != "" {
+			users, err := readHtpasswd(values["htpasswd"])
+			if err != nil {
+				return nil, err
+			}
+			r.users = users
+		}
+		if values["apikeys"] != "" {
+			keys, err := readAPIKeys(values["apikeys"])
+			if err != nil {
+				return nil, err
+			}
+			r.keys = keys
+		}
+		rules.rules = append(rules.rules, r)
+	}
+	return rules, nil
+}
+
+// readLines return the lines of the file without the empty lines and the comments
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// readHtpasswd read the user:hash lines, only the bcrypt hashes are supported
+func readHtpasswd(path string) (map[string][]byte, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	users := map[string][]byte{}
+	for idx, line := range lines {
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("wrong htpasswd %s entry %d", path, idx+1)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("htpasswd %s user %s is not bcrypt", path, user)
+		}
+		users[user] = []byte(hash)
+	}
+	return users, nil
+}
+
+// readAPIKeys read the hex sha256 of the keys, one per line with an optional
+// name: name:hash
+func readAPIKeys(path string) ([]apiKey, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []apiKey
+	for idx, line := range lines {
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			name, hash = fmt.Sprintf("key%d", idx+1), line
+		}
+		sum, err := hex.DecodeString(hash)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("wrong api key %s entry %d", path, idx+1)
+		}
+		keys = append(keys, apiKey{name: name, hash: sum})
+	}
+	return keys, nil
+}
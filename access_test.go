@@ -0,0 +1,179 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeAccessFiles(t *testing.T, dir, password, key string) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "admin.htpasswd"), []byte("# admins\nadmin:"+string(hash)+"\n"), 0600))
+	sum := sha256.Sum256([]byte(key))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "metrics.keys"), []byte("prometheus:"+hex.EncodeToString(sum[:])+"\n"), 0600))
+}
+
+func TestAccessRules(t *testing.T) {
+	dir := t.TempDir()
+	writeAccessFiles(t, dir, "secret", "key-1")
+	conf := filepath.Join(dir, "gin.conf")
+	assert.Nil(t, os.WriteFile(conf, []byte(`gin:
+  mode: test
+  auth:
+    rules:
+      - prefix: /admin
+        realm: Admin
+        htpasswd: `+filepath.Join(dir, "admin.htpasswd")+`
+      - prefix: /metrics
+        header: X-Metrics-Key
+        apikeys: `+filepath.Join(dir, "metrics.keys")+`
+`), 0600))
+	level, mode := zerolog.GlobalLevel(), gin.Mode()
+	t.Cleanup(func() {
+		zerolog.SetGlobalLevel(level)
+		gin.SetMode(mode)
+	})
+	ge, err := NewGin(conf)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, path := range []string{"/admin", "/admin/users", "/administrator", "/metrics", "/public"} {
+		ge.Engine.GET(path, func(c *gin.Context) {
+			c.String(200, "ok "+AccessName(c))
+		})
+	}
+	do := func(path string, f func(req *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/plain")
+		if f != nil {
+			f(req)
+		}
+		w := httptest.NewRecorder()
+		ge.Engine.ServeHTTP(w, req)
+		return w
+	}
+	basic := func(user, password string) func(req *http.Request) {
+		return func(req *http.Request) { req.SetBasicAuth(user, password) }
+	}
+	apiKey := func(key string) func(req *http.Request) {
+		return func(req *http.Request) { req.Header.Set("X-Metrics-Key", key) }
+	}
+
+	w := do("/admin/users", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="Admin"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "401 Unauthorized: access denied", w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, do("/admin", basic("admin", "wrong")).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("/admin", basic("nobody", "secret")).Code)
+	assert.Equal(t, "ok admin", do("/admin/users", basic("admin", "secret")).Body.String())
+	assert.Equal(t, "ok ", do("/administrator", nil).Body.String())
+
+	w = do("/metrics", apiKey("key-2"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "ok prometheus", do("/metrics", apiKey("key-1")).Body.String())
+	assert.Equal(t, "ok ", do("/public", nil).Body.String())
+
+	// the files are re-read by Reload
+	writeAccessFiles(t, dir, "changed", "key-2")
+	assert.Equal(t, 200, do("/admin", basic("admin", "secret")).Code)
+	assert.Nil(t, ge.Reload())
+	assert.Equal(t, http.StatusUnauthorized, do("/admin", basic("admin", "secret")).Code)
+	assert.Equal(t, 200, do("/admin", basic("admin", "changed")).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("/metrics", apiKey("key-1")).Code)
+	assert.Equal(t, 200, do("/metrics", apiKey("key-2")).Code)
+
+	assert.Nil(t, os.Remove(filepath.Join(dir, "metrics.keys")))
+	assert.NotNil(t, ge.Reload())
+	assert.Equal(t, 200, do("/metrics", apiKey("key-2")).Code, "failed reload keep the rules")
+}
+
+func TestParseAccessRules(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "plain.htpasswd"), []byte("admin:{SHA}abc\n"), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bad.keys"), []byte("abc\n"), 0600))
+	for _, rules := range [][]interface{}{
+		{map[interface{}]interface{}{"htpasswd": "x"}},
+		{map[interface{}]interface{}{"prefix": "/admin"}},
+		{map[interface{}]interface{}{"prefix": "/admin", "htpasswd": filepath.Join(dir, "plain.htpasswd")}},
+		{map[interface{}]interface{}{"prefix": "/admin", "apikeys": filepath.Join(dir, "bad.keys")}},
+		{map[interface{}]interface{}{"prefix": "/admin", "apikeys": filepath.Join(dir, "missing.keys")}},
+	} {
+		_, err := parseAccessRules(rules)
+		assert.NotNil(t, err, rules)
+	}
+	_, err := parseAccessRules("rules")
+	assert.NotNil(t, err)
+}
+
+func TestAccessRulesLongestPrefix(t *testing.T) {
+	dir := t.TempDir()
+	writeAccessFiles(t, dir, "secret", "key-1")
+	conf := filepath.Join(dir, "gin.conf")
+	assert.Nil(t, os.WriteFile(conf, []byte(`gin:
+  mode: test
+  auth:
+    rules:
+      - prefix: /
+        realm: Site
+        htpasswd: `+filepath.Join(dir, "admin.htpasswd")+`
+      - prefix: /api
+        header: X-Metrics-Key
+        apikeys: `+filepath.Join(dir, "metrics.keys")+`
+      - prefix: /api/admin
+        realm: Admin
+        htpasswd: `+filepath.Join(dir, "admin.htpasswd")+`
+`), 0600))
+	level, mode := zerolog.GlobalLevel(), gin.Mode()
+	t.Cleanup(func() {
+		zerolog.SetGlobalLevel(level)
+		gin.SetMode(mode)
+	})
+	ge, err := NewGin(conf)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, path := range []string{"/home", "/api/items", "/api/admin/users"} {
+		ge.Engine.GET(path, func(c *gin.Context) {
+			c.String(200, "ok "+AccessName(c))
+		})
+	}
+	do := func(path string, f func(req *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/plain")
+		f(req)
+		w := httptest.NewRecorder()
+		ge.Engine.ServeHTTP(w, req)
+		return w
+	}
+	none := func(req *http.Request) {}
+	basic := func(req *http.Request) { req.SetBasicAuth("admin", "secret") }
+	apiKey := func(req *http.Request) { req.Header.Set("X-Metrics-Key", "key-1") }
+
+	w := do("/api/admin/users", apiKey)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the API key of /api is not accepted by /api/admin")
+	assert.Equal(t, `Basic realm="Admin"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "ok admin", do("/api/admin/users", basic).Body.String())
+
+	assert.Equal(t, "ok prometheus", do("/api/items", apiKey).Body.String())
+	assert.Equal(t, http.StatusUnauthorized, do("/api/items", basic).Code, "the rule of / is not used for /api")
+
+	w = do("/home", none)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="Site"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "ok admin", do("/home", basic).Body.String())
+}
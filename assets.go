@@ -0,0 +1,314 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+// DefaultAssetMaxAge is the cache lifetime of the fingerprinted assets
+const DefaultAssetMaxAge = 365 * 24 * time.Hour
+
+// AssetOptions is the configuration of the fingerprinted assets
+type AssetOptions struct {
+	// Manifest is a JSON file of the fingerprinted URLs instead of hashing
+	// the static files, see LoadAssetManifest
+	Manifest string
+	// MaxAge is the cache lifetime of the fingerprinted URLs
+	MaxAge time.Duration
+}
+
+// Asset is a static file with its fingerprinted URL
+type Asset struct {
+	// URL is the URL of the static file such as /html/js/vue.js
+	URL string
+	// Path is the fingerprinted URL such as /html/js/vue-3b1f7a0c9d2e4f56.js
+	Path string
+	// Integrity is the subresource integrity of the file
+	Integrity string
+	file      string
+}
+
+// AssetManager map the static files to their fingerprinted URLs, the
+// fingerprinted URLs are served with immutable cache headers
+type AssetManager struct {
+	mounts  []string
+	statics map[string]string
+	assets  map[string]*Asset
+	served  map[string]*Asset
+	maxAge  time.Duration
+}
+
+// NewAssetManager hash the files under the static mounts of mapping to
+// directory, the hidden files are skipped
+func NewAssetManager(statics map[string]string, options AssetOptions) (*AssetManager, error) {
+	a := &AssetManager{
+		statics: statics,
+		assets:  map[string]*Asset{},
+		served:  map[string]*Asset{},
+		maxAge:  options.MaxAge,
+	}
+	if a.maxAge <= 0 {
+		a.maxAge = DefaultAssetMaxAge
+	}
+	for mapping := range statics {
+		a.mounts = append(a.mounts, mapping)
+	}
+	sort.Strings(a.mounts)
+	if options.Manifest != "" {
+		return a, a.LoadAssetManifest(options.Manifest)
+	}
+	for _, mapping := range a.mounts {
+		dir := statics[mapping]
+		err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(d.Name(), ".") && file != dir {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				return err
+			}
+			url := path.Join(mapping, filepath.ToSlash(rel))
+			buf, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			sum := sha512.Sum384(buf)
+			a.add(&Asset{
+				URL:       url,
+				Path:      fingerprint(url, hex.EncodeToString(sum[:8])),
+				Integrity: "sha384-" + base64.StdEncoding.EncodeToString(sum[:]),
+				file:      file,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// fingerprint insert the hash before the extension of url
+func fingerprint(url, hash string) string {
+	ext := path.Ext(url)
+	if ext == path.Base(url) {
+		ext = ""
+	}
+	return strings.TrimSuffix(url, ext) + "-" + hash + ext
+}
+
+func (a *AssetManager) add(asset *Asset) {
+	a.assets[asset.URL] = asset
+	a.served[asset.Path] = asset
+}
+
+// LoadAssetManifest read the JSON object of the URLs of the static files to
+// their fingerprinted URLs, or to an object of path and integrity:
+//
+//	{
+//	  "/html/js/vue.js": "/html/js/vue-3b1f7a0c.js",
+//	  "/html/css/app.css": {"path": "/html/css/app-9d2e4f56.css", "integrity": "sha384-..."}
+//	}
+//
+// The fingerprinted files must exist under the static mounts, the integrity
+// is computed from the file if not given.
+func (a *AssetManager) LoadAssetManifest(manifest string) error {
+	buf, err := os.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return fmt.Errorf("wrong asset manifest %s: %w", manifest, err)
+	}
+	for url, raw := range entries {
+		asset := &Asset{URL: url}
+		if err := json.Unmarshal(raw, &asset.Path); err != nil {
+			var entry struct {
+				Path      string `json:"path"`
+				Integrity string `json:"integrity"`
+			}
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return fmt.Errorf("wrong asset manifest entry %s: %w", url, err)
+			}
+			asset.Path, asset.Integrity = entry.Path, entry.Integrity
+		}
+		asset.file = a.file(asset.Path)
+		if asset.file == "" {
+			return fmt.Errorf("asset %s not under the static mounts", asset.Path)
+		}
+		if asset.Integrity == "" {
+			buf, err := os.ReadFile(asset.file)
+			if err != nil {
+				return err
+			}
+			sum := sha512.Sum384(buf)
+			asset.Integrity = "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+		}
+		a.add(asset)
+	}
+	return nil
+}
+
+// file return the file of the url under the static mounts
+func (a *AssetManager) file(url string) string {
+	for _, mapping := range a.mounts {
+		prefix := strings.TrimSuffix(mapping, "/") + "/"
+		if strings.HasPrefix(url, prefix) {
+			return filepath.Join(a.statics[mapping], filepath.FromSlash(url[len(prefix):]))
+		}
+	}
+	return ""
+}
+
+// Asset return the asset of name, which is the URL of the static file such
+// as /html/js/vue.js or relative to the first static mount having it
+func (a *AssetManager) Asset(name string) (*Asset, error) {
+	if a != nil {
+		if strings.HasPrefix(name, "/") {
+			if asset, ok := a.assets[name]; ok {
+				return asset, nil
+			}
+		} else {
+			for _, mapping := range a.mounts {
+				if asset, ok := a.assets[path.Join(mapping, name)]; ok {
+					return asset, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("asset %s not found", name)
+}
+
+// AssetPath return the fingerprinted URL of name
+func (a *AssetManager) AssetPath(name string) (string, error) {
+	asset, err := a.Asset(name)
+	if err != nil {
+		return "", err
+	}
+	return asset.Path, nil
+}
+
+// JavascriptTag return the script element of name with its integrity, the
+// attributes are added to the element, true is an attribute without value
+func (a *AssetManager) JavascriptTag(name string, attributes map[string]interface{}) (template.HTML, error) {
+	asset, err := a.Asset(name)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<script src="` + template.HTMLEscapeString(asset.Path) + `"` +
+		integrityAttributes(asset, attributes) + `></script>`), nil
+}
+
+// StylesheetTag return the link element of name with its integrity, the
+// attributes are added to the element, true is an attribute without value
+func (a *AssetManager) StylesheetTag(name string, attributes map[string]interface{}) (template.HTML, error) {
+	asset, err := a.Asset(name)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<link rel="stylesheet" href="` + template.HTMLEscapeString(asset.Path) + `"` +
+		integrityAttributes(asset, attributes) + `>`), nil
+}
+
+func integrityAttributes(asset *Asset, attributes map[string]interface{}) string {
+	attrs := map[string]interface{}{
+		"integrity":   asset.Integrity,
+		"crossorigin": "anonymous",
+	}
+	for k, v := range attributes {
+		attrs[k] = v
+	}
+	names := make([]string, 0, len(attrs))
+	for k := range attrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		switch v := attrs[k].(type) {
+		case nil:
+		case bool:
+			if v {
+				b.WriteString(" " + template.HTMLEscapeString(k))
+			}
+		default:
+			b.WriteString(" " + template.HTMLEscapeString(k) + `="` + template.HTMLEscapeString(fmt.Sprint(v)) + `"`)
+		}
+	}
+	return b.String()
+}
+
+// Handler serve the fingerprinted URLs with immutable cache headers, the
+// other requests are passed to the next handlers
+func (a *AssetManager) Handler() gin.HandlerFunc {
+	cacheControl := "public, max-age=" + strconv.Itoa(int(a.maxAge/time.Second)) + ", immutable"
+	return func(c *gin.Context) {
+		asset, ok := a.served[c.Request.URL.Path]
+		if !ok || (c.Request.Method != "GET" && c.Request.Method != "HEAD") {
+			c.Next()
+			return
+		}
+		c.Header("Cache-Control", cacheControl)
+		c.File(asset.file)
+		c.Abort()
+	}
+}
+
+// addAssetHelpers register the assetPath, javascriptTag and stylesheetTag
+// helpers of the templates
+func addAssetHelpers(p *plushgin.Plush2Render, a *AssetManager) {
+	p.AddHelper("assetPath", a.AssetPath)
+	p.AddHelper("javascriptTag", a.JavascriptTag)
+	p.AddHelper("stylesheetTag", a.StylesheetTag)
+}
+
+// parseAssetOptions parse the assets of gin.conf, an empty section use the
+// defaults
+func parseAssetOptions(m interface{}) (*AssetOptions, error) {
+	o := &AssetOptions{}
+	if mm, err := extract(m, "manifest"); err == nil {
+		ss, ok := mm.(string)
+		if !ok || ss == "" {
+			return nil, fmt.Errorf("wrong type assets manifest")
+		}
+		o.Manifest = ss
+	}
+	if mm, err := extract(m, "maxage"); err == nil {
+		n, ok := mm.(int)
+		if !ok || n <= 0 {
+			return nil, fmt.Errorf("wrong type assets maxage")
+		}
+		o.MaxAge = time.Duration(n) * time.Second
+	}
+	return o, nil
+}
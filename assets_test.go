@@ -0,0 +1,139 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func TestAssets(t *testing.T) {
+	vue, _ := os.ReadFile("testdata/static/js/vue.js")
+	sum := sha512.Sum384(vue)
+	vuePath := "/html/js/vue-" + hex.EncodeToString(sum[:8]) + ".js"
+	integrity := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+
+	a, err := NewAssetManager(map[string]string{
+		"/html":   "testdata/static",
+		"/images": "testdata/static/images",
+	}, AssetOptions{})
+	assert.Nil(t, err)
+	for _, name := range []string{"js/vue.js", "/html/js/vue.js"} {
+		p, err := a.AssetPath(name)
+		assert.Nil(t, err)
+		assert.Equal(t, vuePath, p)
+	}
+	p, _ := a.AssetPath("favicon.png")
+	assert.Regexp(t, `^/images/favicon-[0-9a-f]{16}\.png$`, p)
+	_, err = a.AssetPath("js/missing.js")
+	assert.EqualError(t, err, "asset js/missing.js not found")
+
+	tag, err := a.JavascriptTag("js/vue.js", map[string]interface{}{"defer": true, "async": false, "id": `"x"`})
+	assert.Nil(t, err)
+	assert.Equal(t, `<script src="`+vuePath+`" crossorigin="anonymous" defer id="&#34;x&#34;" integrity="`+integrity+`"></script>`, string(tag))
+	tag, _ = a.StylesheetTag("test.txt", nil)
+	assert.Regexp(t, `^<link rel="stylesheet" href="/html/test-[0-9a-f]{16}\.txt" crossorigin="anonymous" integrity="sha384-[^"]+">$`, string(tag))
+
+	render := plushgin.Default()
+	render.Options.TemplateDir = t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(render.Options.TemplateDir, "index.html"),
+		[]byte(`<%= assetPath("js/vue.js") %>|<%= javascriptTag("js/vue.js") %>`), 0600))
+	addAssetHelpers(render, a)
+	engine := gin.New()
+	engine.HTMLRender = render
+	engine.Use(a.Handler())
+	engine.Static("/html", "testdata/static")
+	engine.GET("/", func(c *gin.Context) {
+		c.HTML(200, "index.html", nil)
+	})
+	get := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := get("GET", "/")
+	assert.Equal(t, vuePath+`|<script src="`+vuePath+`" crossorigin="anonymous" integrity="`+integrity+`"></script>`, w.Body.String())
+
+	w = get("GET", vuePath)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	assert.Equal(t, string(vue), w.Body.String())
+	w = get("HEAD", vuePath)
+	assert.Equal(t, 200, w.Code)
+
+	w = get("GET", "/html/js/vue.js")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Cache-Control"))
+	w = get("GET", "/html/js/vue-0000000000000000.js")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestAssetManifest(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "js"), 0700))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "js", "app-1234.js"), []byte("app"), 0600))
+	manifest := filepath.Join(dir, "manifest.json")
+	write := func(content string) {
+		assert.Nil(t, os.WriteFile(manifest, []byte(content), 0600))
+	}
+	statics := map[string]string{"/static": dir}
+
+	write(`{"/static/js/app.js": "/static/js/app-1234.js",
+		"/static/css/app.css": {"path": "/static/js/app-1234.js", "integrity": "sha384-given"}}`)
+	a, err := NewAssetManager(statics, AssetOptions{Manifest: manifest, MaxAge: 3600e9})
+	assert.Nil(t, err)
+	asset, err := a.Asset("js/app.js")
+	if assert.Nil(t, err) {
+		sum := sha512.Sum384([]byte("app"))
+		assert.Equal(t, "/static/js/app-1234.js", asset.Path)
+		assert.Equal(t, "sha384-"+base64.StdEncoding.EncodeToString(sum[:]), asset.Integrity)
+	}
+	asset, _ = a.Asset("/static/css/app.css")
+	assert.Equal(t, "sha384-given", asset.Integrity)
+	_, err = a.Asset("manifest.json")
+	assert.NotNil(t, err, "the files are not hashed with a manifest")
+
+	engine := gin.New()
+	engine.Use(a.Handler())
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/static/js/app-1234.js", nil))
+	assert.Equal(t, "app", w.Body.String())
+	assert.Equal(t, "public, max-age=3600, immutable", w.Header().Get("Cache-Control"))
+
+	for _, content := range []string{
+		`[]`,
+		`{"/static/js/app.js": 1}`,
+		`{"/static/js/app.js": "/other/app.js"}`,
+		`{"/static/js/app.js": "/static/js/missing.js"}`,
+	} {
+		write(content)
+		_, err = NewAssetManager(statics, AssetOptions{Manifest: manifest})
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestParseAssetOptions(t *testing.T) {
+	o, err := parseAssetOptions(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, &AssetOptions{}, o)
+	o, err = parseAssetOptions(map[interface{}]interface{}{"manifest": "m.json", "maxage": 60})
+	assert.Nil(t, err)
+	assert.Equal(t, &AssetOptions{Manifest: "m.json", MaxAge: 60e9}, o)
+	_, err = parseAssetOptions(map[interface{}]interface{}{"maxage": "1d"})
+	assert.NotNil(t, err)
+	_, err = parseAssetOptions(map[interface{}]interface{}{"manifest": 1})
+	assert.NotNil(t, err)
+}
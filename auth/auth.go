@@ -0,0 +1,282 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package auth is the login of the users kept in the gintool session.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gobuffalo/plush"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/cytown/gintool"
+)
+
+var (
+	// ErrUserNotFound is returned by the UserProvider if the user not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidCredentials is returned by Login if the login or the password is wrong
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	// ErrUnauthorized is the error response of the request without login
+	ErrUnauthorized = errors.New("login required")
+	// ErrForbidden is the error response of the user without the required role
+	ErrForbidden = errors.New("permission denied")
+	// ErrNoSession is returned if the request has no session
+	ErrNoSession = errors.New("no session, use gintool.UseSession")
+)
+
+const userContextKey = "_auth_user_"
+
+// User is the logged in user
+type User interface {
+	// UserID is the id saved in the session
+	UserID() string
+	// Roles is the roles checked by RequireRole
+	Roles() []string
+}
+
+// UserProvider find the users of the application
+type UserProvider interface {
+	// FindByLogin return the user and its bcrypt password hash, ErrUserNotFound
+	// if not exist
+	FindByLogin(login string) (User, []byte, error)
+	// FindByID return the user of the id saved in the session, ErrUserNotFound
+	// if not exist
+	FindByID(id string) (User, error)
+}
+
+// Options is the configuration of Auth
+type Options struct {
+	// LoginURL is where the browsers are redirected by RequireLogin, the
+	// requested URL is added as the next parameter
+	LoginURL string
+}
+
+// DefaultOptions return the default options
+func DefaultOptions() Options {
+	return Options{LoginURL: "/login"}
+}
+
+// Auth login the users of the provider with the session
+type Auth struct {
+	provider UserProvider
+	options  Options
+}
+
+// New create an Auth
+func New(provider UserProvider, options Options) *Auth {
+	if options.LoginURL == "" {
+		options.LoginURL = DefaultOptions().LoginURL
+	}
+	return &Auth{provider: provider, options: options}
+}
+
+// NewFromConfig create an Auth with the auth section of gin.conf:
+//
+//	auth:
+//	  login: /login
+func NewFromConfig(provider UserProvider, config *gintool.Config) (*Auth, error) {
+	options := DefaultOptions()
+	if v := config.Section("auth", "login"); v != nil {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("wrong type auth login")
+		}
+		if _, err := url.Parse(s); err != nil {
+			return nil, fmt.Errorf("wrong auth login: %w", err)
+		}
+		options.LoginURL = s
+	}
+	return New(provider, options), nil
+}
+
+// Install load the user of every request and register the currentUser
+// helper to the templates
+func (a *Auth) Install(ge *gintool.GinEngine) {
+	ge.Engine.Use(a.Load())
+	ge.AddContextFunc(func(c *gin.Context) map[string]interface{} {
+		return map[string]interface{}{userContextKey: CurrentUser(c)}
+	})
+	ge.AddHelper("currentUser", func(help plush.HelperContext) User {
+		user, _ := help.Value(userContextKey).(User)
+		return user
+	})
+}
+
+// HashPassword return the bcrypt hash of the password
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// dummyHash is compared when the user not exist, so the response time
+// doesn't tell if the login exist
+var dummyHash, _ = HashPassword("gintool dummy password")
+
+// Login check the password and save the user in the session, the session id
+// is regenerated to prevent session fixation
+func (a *Auth) Login(c *gin.Context, login, password string) (User, error) {
+	user, hash, err := a.provider.FindByLogin(login)
+	if errors.Is(err, ErrUserNotFound) {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if err := LoginUser(c, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// LoginUser save the user in the session without checking the password,
+// the session id is regenerated. The session is indexed by the user id, so
+// gintool.RevokeUser can logout the user.
+func LoginUser(c *gin.Context, user User) error {
+	s := gintool.FromGin(c)
+	if s == nil {
+		return ErrNoSession
+	}
+	if err := s.RegenerateID(); err != nil {
+		return err
+	}
+	s.SetUserID(user.UserID())
+	setUser(c, user)
+	return nil
+}
+
+// Logout destroy the session of the user
+func Logout(c *gin.Context) error {
+	s := gintool.FromGin(c)
+	if s == nil {
+		return nil
+	}
+	setUser(c, nil)
+	s.SetUserID("")
+	return s.Destroy()
+}
+
+// CurrentUser return the user loaded by Load, RequireLogin, RequireRole or
+// Login, nil if not logged in
+func CurrentUser(c *gin.Context) User {
+	v, _ := c.Get(userContextKey)
+	user, _ := v.(User)
+	return user
+}
+
+func setUser(c *gin.Context, user User) {
+	c.Set(userContextKey, user)
+}
+
+// user load the user of the session once per request
+func (a *Auth) user(c *gin.Context) (User, error) {
+	if v, ok := c.Get(userContextKey); ok {
+		user, _ := v.(User)
+		return user, nil
+	}
+	s := gintool.FromGin(c)
+	if s == nil {
+		return nil, nil
+	}
+	id := s.UserID()
+	if id == "" {
+		return nil, nil
+	}
+	user, err := a.provider.FindByID(id)
+	if errors.Is(err, ErrUserNotFound) {
+		s.SetUserID("")
+		user, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	setUser(c, user)
+	return user, nil
+}
+
+// Load is a middleware which load the user of the session, it doesn't
+// require the login
+func (a *Auth) Load() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := a.user(c); err != nil {
+			gintool.ErrorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireLogin is a middleware which redirect the browsers to the login URL
+// and send 401 to the other clients if not logged in
+func (a *Auth) RequireLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.require(c) != nil {
+			c.Next()
+		}
+	}
+}
+
+// RequireRole is a middleware which require the login and any of the roles,
+// the user without the roles get 403
+func (a *Auth) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := a.require(c)
+		if user == nil {
+			return
+		}
+		for _, role := range user.Roles() {
+			for _, r := range roles {
+				if role == r {
+					c.Next()
+					return
+				}
+			}
+		}
+		gintool.ErrorResponse(c, http.StatusForbidden, ErrForbidden)
+	}
+}
+
+// loginURL return LoginURL with the next parameter added to its query
+func (a *Auth) loginURL(next string) (string, error) {
+	u, err := url.Parse(a.options.LoginURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("next", next)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// require return the user, the response is written if nil
+func (a *Auth) require(c *gin.Context) User {
+	user, err := a.user(c)
+	if err != nil {
+		gintool.ErrorResponse(c, http.StatusInternalServerError, err)
+		return nil
+	}
+	if user != nil {
+		return user
+	}
+	if gintool.IsBrowser(c) {
+		location, err := a.loginURL(c.Request.URL.RequestURI())
+		if err != nil {
+			gintool.ErrorResponse(c, http.StatusInternalServerError, err)
+			return nil
+		}
+		c.Redirect(http.StatusFound, location)
+		c.Abort()
+		return nil
+	}
+	gintool.ErrorResponse(c, http.StatusUnauthorized, ErrUnauthorized)
+	return nil
+}
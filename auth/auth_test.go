@@ -0,0 +1,179 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool"
+)
+
+type testUser struct {
+	id    string
+	roles []string
+	hash  []byte
+}
+
+func (u *testUser) UserID() string  { return u.id }
+func (u *testUser) Roles() []string { return u.roles }
+
+type testProvider map[string]*testUser
+
+func (p testProvider) FindByLogin(login string) (User, []byte, error) {
+	u, ok := p[login]
+	if !ok {
+		return nil, nil, ErrUserNotFound
+	}
+	return u, u.hash, nil
+}
+
+func (p testProvider) FindByID(id string) (User, error) {
+	u, ok := p[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func newTestProvider(t *testing.T) testProvider {
+	hash, err := HashPassword("secret")
+	assert.Nil(t, err)
+	return testProvider{
+		"tom":   {id: "tom", roles: []string{"admin"}, hash: hash},
+		"jerry": {id: "jerry", hash: hash},
+	}
+}
+
+func authEngine(t *testing.T, provider UserProvider) *gin.Engine {
+	ge, err := gintool.NewGin("testdata/auth.conf")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	a, err := NewFromConfig(provider, ge.Config())
+	assert.Nil(t, err)
+	a.Install(ge)
+	engine := ge.Engine
+	engine.POST("/signin", func(c *gin.Context) {
+		if _, err := a.Login(c, c.PostForm("login"), c.PostForm("password")); err != nil {
+			c.String(http.StatusUnauthorized, err.Error())
+			return
+		}
+		c.String(200, "ok")
+	})
+	engine.POST("/logout", func(c *gin.Context) {
+		assert.Nil(t, Logout(c))
+		c.String(200, "bye")
+	})
+	engine.GET("/user", func(c *gin.Context) {
+		c.HTML(200, "user.html", gin.H{})
+	})
+	engine.GET("/private", a.RequireLogin(), func(c *gin.Context) {
+		c.String(200, CurrentUser(c).UserID())
+	})
+	engine.GET("/admin", a.RequireRole("admin"), func(c *gin.Context) {
+		c.String(200, "admin")
+	})
+	return engine
+}
+
+func request(engine *gin.Engine, method, path, body, accept string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", accept)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	engine := authEngine(t, newTestProvider(t))
+
+	w := request(engine, "GET", "/private?a=1", "", "text/html", nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/signin?next=%2Fprivate%3Fa%3D1", w.Header().Get("Location"))
+	w = request(engine, "GET", "/private", "", "application/json", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(engine, "GET", "/user", "", "text/html", nil)
+	assert.Equal(t, "guest", strings.TrimSpace(w.Body.String()))
+
+	w = request(engine, "POST", "/signin", "login=tom&password=wrong", "text/plain", nil)
+	assert.Equal(t, ErrInvalidCredentials.Error(), w.Body.String())
+	w = request(engine, "POST", "/signin", "login=nobody&password=secret", "text/plain", nil)
+	assert.Equal(t, ErrInvalidCredentials.Error(), w.Body.String())
+
+	// a fixed session id is replaced on login
+	w = request(engine, "GET", "/user", "", "text/html", nil)
+	before := w.Result().Cookies()
+	w = request(engine, "POST", "/signin", "login=tom&password=secret", "text/plain", before)
+	assert.Equal(t, "ok", w.Body.String())
+	cookies := w.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+	for _, cookie := range before {
+		assert.NotEqual(t, cookie.Value, cookies[0].Value)
+	}
+
+	w = request(engine, "GET", "/private", "", "text/html", cookies)
+	assert.Equal(t, "tom", w.Body.String())
+	w = request(engine, "GET", "/admin", "", "text/html", cookies)
+	assert.Equal(t, "admin", w.Body.String())
+	w = request(engine, "GET", "/user", "", "text/html", cookies)
+	assert.Equal(t, "tom", strings.TrimSpace(w.Body.String()))
+
+	w = request(engine, "POST", "/logout", "", "text/plain", cookies)
+	assert.Equal(t, "bye", w.Body.String())
+	w = request(engine, "GET", "/private", "", "application/json", cookies)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRequireRole(t *testing.T) {
+	engine := authEngine(t, newTestProvider(t))
+	w := request(engine, "POST", "/signin", "login=jerry&password=secret", "text/plain", nil)
+	cookies := w.Result().Cookies()
+	w = request(engine, "GET", "/admin", "", "application/json", cookies)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request(engine, "GET", "/private", "", "application/json", cookies)
+	assert.Equal(t, "jerry", w.Body.String())
+}
+
+type brokenProvider struct{ testProvider }
+
+func (brokenProvider) FindByID(id string) (User, error) {
+	return nil, errors.New("database down")
+}
+
+func TestProviderError(t *testing.T) {
+	engine := authEngine(t, brokenProvider{newTestProvider(t)})
+	w := request(engine, "POST", "/signin", "login=tom&password=secret", "text/plain", nil)
+	cookies := w.Result().Cookies()
+	w = request(engine, "GET", "/private", "", "text/plain", cookies)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestLoginURL(t *testing.T) {
+	for login, want := range map[string]string{
+		"/login":                  "/login?next=%2Fprivate%3Fa%3D1",
+		"/login?tenant=x":         "/login?next=%2Fprivate%3Fa%3D1&tenant=x",
+		"https://sso.example/in?": "https://sso.example/in?next=%2Fprivate%3Fa%3D1",
+	} {
+		a := New(newTestProvider(t), Options{LoginURL: login})
+		location, err := a.loginURL("/private?a=1")
+		assert.Nil(t, err)
+		assert.Equal(t, want, location, login)
+	}
+}
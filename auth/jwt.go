@@ -0,0 +1,504 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/cytown/gintool"
+)
+
+// The supported JWT algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// ErrInvalidToken is the error of the bearer token which can't be verified,
+// the returned errors wrap it with the reason
+var ErrInvalidToken = errors.New("invalid token")
+
+const claimsContextKey = "_auth_claims_"
+
+type claimsKey struct{}
+
+// Claims is the verified claims of the JWT
+type Claims map[string]interface{}
+
+// String return the claim as string, empty if not exist or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Subject return the sub claim
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// time return the numeric date claim
+func (c Claims) time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+	}
+	return time.Unix(0, int64(f*float64(time.Second))), true, nil
+}
+
+// audience check the aud claim, which is a string or an array of string
+func (c Claims) audience(aud string) bool {
+	switch v := c["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClaimsFromContext return the claims verified by JWT, nil if not exist.
+// ctx can be the *gin.Context or the request context.
+func ClaimsFromContext(ctx context.Context) Claims {
+	if c, ok := ctx.(*gin.Context); ok {
+		if v, ok := c.Get(claimsContextKey); ok {
+			return v.(Claims)
+		}
+		if c.Request == nil {
+			return nil
+		}
+		ctx = c.Request.Context()
+	}
+	claims, _ := ctx.Value(claimsKey{}).(Claims)
+	return claims
+}
+
+type jwtKey struct {
+	id  string
+	alg string
+	key interface{}
+}
+
+// KeySet is the keys used to verify the JWT
+type KeySet struct {
+	keys []jwtKey
+}
+
+// AddKey add the key of the algorithm, key is []byte for HS256,
+// *rsa.PublicKey for RS256 and *ecdsa.PublicKey of P-256 for ES256.
+// The tokens with a kid header are only verified by the key of the same id,
+// or by the keys without id such as the one of a keyfile.
+func (ks *KeySet) AddKey(id, alg string, key interface{}) error {
+	ok := false
+	switch alg {
+	case HS256:
+		var b []byte
+		b, ok = key.([]byte)
+		ok = ok && len(b) > 0
+	case RS256:
+		_, ok = key.(*rsa.PublicKey)
+	case ES256:
+		var k *ecdsa.PublicKey
+		k, ok = key.(*ecdsa.PublicKey)
+		ok = ok && k.Curve == elliptic.P256()
+	default:
+		return fmt.Errorf("unsupported jwt alg %s", alg)
+	}
+	if !ok {
+		return fmt.Errorf("wrong key %T for jwt alg %s", key, alg)
+	}
+	ks.keys = append(ks.keys, jwtKey{id: id, alg: alg, key: key})
+	return nil
+}
+
+// Len return the number of keys
+func (ks *KeySet) Len() int {
+	return len(ks.keys)
+}
+
+// LoadKeyFile add the key of the file, the secret for HS256 or the PEM
+// encoded public key or certificate for RS256 and ES256
+func (ks *KeySet) LoadKeyFile(id, alg, path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if alg == HS256 {
+		return ks.AddKey(id, alg, bytes.TrimSpace(buf))
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return fmt.Errorf("no PEM data in %s", path)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return fmt.Errorf("unsupported PEM type %s in %s", block.Type, path)
+	}
+	if err != nil {
+		return err
+	}
+	return ks.AddKey(id, alg, key)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS add the keys of a local JWKS file, the keys not for signature
+// or of unsupported types are skipped
+func (ks *KeySet) LoadJWKS(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(buf, &set); err != nil {
+		return fmt.Errorf("wrong jwks %s: %w", path, err)
+	}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		alg, key, err := k.parse()
+		if err != nil {
+			return fmt.Errorf("wrong jwks %s key %s: %w", path, k.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		if err := ks.AddKey(k.Kid, alg, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *jwk) parse() (string, interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch {
+	case k.Kty == "oct" && (k.Alg == "" || k.Alg == HS256):
+		key, err := decode(k.K)
+		return HS256, key, err
+	case k.Kty == "RSA" && (k.Alg == "" || k.Alg == RS256):
+		n, err := decode(k.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return "", nil, err
+		}
+		return RS256, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case k.Kty == "EC" && k.Crv == "P-256" && (k.Alg == "" || k.Alg == ES256):
+		x, err := decode(k.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		return ES256, &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return "", nil, nil
+}
+
+// JWTOptions is the configuration of the JWT middleware
+type JWTOptions struct {
+	// Keys verify the signature
+	Keys *KeySet
+	// Issuer is the required iss claim if not empty
+	Issuer string
+	// Audience is the required aud claim if not empty
+	Audience string
+	// Skew is the clock skew allowed when checking exp and nbf
+	Skew time.Duration
+	// RequireExp reject the tokens without exp, which never expire
+	RequireExp bool
+	// Now return the current time, time.Now if nil
+	Now func() time.Time
+}
+
+// DefaultJWTOptions return the default options, the tokens without exp are rejected
+func DefaultJWTOptions() JWTOptions {
+	return JWTOptions{Keys: &KeySet{}, RequireExp: true}
+}
+
+// Verify check the signature and the claims of the token
+func (o *JWTOptions) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	if !o.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], sig) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, o.checkClaims(claims)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	return nil
+}
+
+// verifySignature try the keys of the algorithm, the alg of the key must
+// match the header so a public key can't be used as a HMAC secret
+func (o *JWTOptions) verifySignature(alg, kid, signed string, sig []byte) bool {
+	if o.Keys == nil {
+		return false
+	}
+	hash := sha256.Sum256([]byte(signed))
+	for _, k := range o.Keys.keys {
+		if k.alg != alg || (kid != "" && k.id != "" && k.id != kid) {
+			continue
+		}
+		switch key := k.key.(type) {
+		case []byte:
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if len(sig) == 64 && ecdsa.Verify(key, hash[:],
+				new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (o *JWTOptions) checkClaims(claims Claims) error {
+	now := time.Now()
+	if o.Now != nil {
+		now = o.Now()
+	}
+	exp, ok, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok && o.RequireExp {
+		return fmt.Errorf("%w: exp required", ErrInvalidToken)
+	}
+	if ok && now.After(exp.Add(o.Skew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	nbf, ok, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(o.Skew).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if o.Issuer != "" && claims.String("iss") != o.Issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if o.Audience != "" && !claims.audience(o.Audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+// JWT is a middleware which verify the bearer token of the Authorization
+// header, the claims are put on the request context. The request without a
+// valid token get 401.
+func JWT(options JWTOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			gintool.ErrorResponse(c, http.StatusUnauthorized, fmt.Errorf("%w: bearer token required", ErrInvalidToken))
+			return
+		}
+		claims, err := options.Verify(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			gintool.ErrorResponse(c, http.StatusUnauthorized, err)
+			return
+		}
+		c.Set(claimsContextKey, claims)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), claimsKey{}, claims))
+		c.Next()
+	}
+}
+
+func bearerToken(header string) (string, bool) {
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// JWTFromConfig create the JWT middleware with the auth.jwt section of gin.conf
+func JWTFromConfig(config *gintool.Config) (gin.HandlerFunc, error) {
+	m := config.Section("auth", "jwt")
+	if m == nil {
+		return nil, fmt.Errorf("auth jwt is not configured")
+	}
+	options, err := ParseJWTOptions(m)
+	if err != nil {
+		return nil, err
+	}
+	return JWT(options), nil
+}
+
+// ParseJWTOptions read the jwt configuration:
+//
+//	jwt:
+//	  alg: RS256         # alg of keyfile, HS256/RS256/ES256
+//	  keyfile: jwt.pem   # the HS256 secret or the PEM public key
+//	  jwks: jwks.json    # local JWKS file
+//	  issuer: https://auth.example.com
+//	  audience: api
+//	  skew: 30           # seconds
+//	  requireexp: true   # reject the tokens without exp
+func ParseJWTOptions(m interface{}) (JWTOptions, error) {
+	options := DefaultJWTOptions()
+	values := map[string]string{}
+	for _, name := range []string{"alg", "keyfile", "jwks", "issuer", "audience"} {
+		v, ok := section(m, name)
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return options, fmt.Errorf("wrong type auth jwt %s", name)
+		}
+		values[name] = s
+	}
+	if values["keyfile"] != "" {
+		alg := values["alg"]
+		if alg == "" {
+			return options, fmt.Errorf("auth jwt alg is required by keyfile")
+		}
+		if err := options.Keys.LoadKeyFile("", alg, values["keyfile"]); err != nil {
+			return options, err
+		}
+	}
+	if values["jwks"] != "" {
+		if err := options.Keys.LoadJWKS(values["jwks"]); err != nil {
+			return options, err
+		}
+	}
+	if options.Keys.Len() == 0 {
+		return options, fmt.Errorf("auth jwt keyfile or jwks is required")
+	}
+	options.Issuer = values["issuer"]
+	options.Audience = values["audience"]
+	if v, ok := section(m, "skew"); ok {
+		seconds, ok := v.(int)
+		if !ok || seconds < 0 {
+			return options, fmt.Errorf("wrong type auth jwt skew")
+		}
+		options.Skew = time.Duration(seconds) * time.Second
+	}
+	if v, ok := section(m, "requireexp"); ok {
+		b, ok := v.(bool)
+		if !ok {
+			return options, fmt.Errorf("wrong type auth jwt requireexp")
+		}
+		options.RequireExp = b
+	}
+	return options, nil
+}
+
+// section return the value of the yaml mapping
+func section(m interface{}, name string) (interface{}, bool) {
+	mm, ok := m.(map[interface{}]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := mm[name]
+	return v, ok
+}
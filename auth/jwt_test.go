@@ -0,0 +1,245 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	hash := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, hash[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	assert.Nil(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return testKeys{secret: []byte("test secret"), rsa: rsaKey, ec: ecKey}
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	ks := &KeySet{}
+	assert.Nil(t, ks.AddKey("", HS256, keys.secret))
+	assert.Nil(t, ks.AddKey("rsa", RS256, &keys.rsa.PublicKey))
+	assert.Nil(t, ks.AddKey("ec", ES256, &keys.ec.PublicKey))
+	assert.NotNil(t, ks.AddKey("", RS256, keys.secret))
+	assert.NotNil(t, ks.AddKey("", "none", nil))
+
+	now := time.Unix(1700000000, 0)
+	options := JWTOptions{
+		Keys:       ks,
+		Issuer:     "https://auth.example.com",
+		Audience:   "api",
+		Skew:       30 * time.Second,
+		RequireExp: true,
+		Now:        func() time.Time { return now },
+	}
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "tom",
+			"iss": "https://auth.example.com",
+			"aud": []string{"web", "api"},
+			"exp": now.Add(time.Minute).Unix(),
+			"nbf": now.Unix(),
+		}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"hs256", signToken(t, HS256, "", keys.secret, claims(nil)), ""},
+		{"rs256", signToken(t, RS256, "rsa", keys.rsa, claims(nil)), ""},
+		{"es256", signToken(t, ES256, "ec", keys.ec, claims(nil)), ""},
+		{"rs256 without kid", signToken(t, RS256, "", keys.rsa, claims(nil)), ""},
+		{"hs256 kid of a key without id", signToken(t, HS256, "other", keys.secret, claims(nil)), ""},
+		{"wrong kid", signToken(t, ES256, "rsa", keys.ec, claims(nil)), "bad signature"},
+		{"wrong secret", signToken(t, HS256, "", []byte("other"), claims(nil)), "bad signature"},
+		{"alg none", signToken(t, "none", "", []byte{}, claims(nil)), "bad signature"},
+		{"expired", signToken(t, HS256, "", keys.secret, claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), "expired"},
+		{"without exp", signToken(t, HS256, "", keys.secret, claims(map[string]interface{}{"exp": nil})), "exp required"},
+		{"expired in skew", signToken(t, HS256, "", keys.secret, claims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()})), ""},
+		{"not valid yet", signToken(t, HS256, "", keys.secret, claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})), "not valid yet"},
+		{"wrong issuer", signToken(t, HS256, "", keys.secret, claims(map[string]interface{}{"iss": "other"})), "wrong issuer"},
+		{"wrong audience", signToken(t, HS256, "", keys.secret, claims(map[string]interface{}{"aud": "web"})), "wrong audience"},
+		{"string audience", signToken(t, HS256, "", keys.secret, claims(map[string]interface{}{"aud": "api"})), ""},
+		{"malformed", "a.b", "malformed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := options.Verify(tt.token)
+			if tt.err == "" {
+				assert.Nil(t, err)
+				assert.Equal(t, "tom", c.Subject())
+				return
+			}
+			assert.True(t, errors.Is(err, ErrInvalidToken))
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	keys := newTestKeys(t)
+	options := JWTOptions{Keys: &KeySet{}}
+	assert.Nil(t, options.Keys.AddKey("", HS256, keys.secret))
+	engine := gin.New()
+	engine.GET("/api", JWT(options), func(c *gin.Context) {
+		assert.Equal(t, "tom", ClaimsFromContext(c.Request.Context()).Subject())
+		c.String(200, ClaimsFromContext(c).Subject())
+	})
+	token := signToken(t, HS256, "", keys.secret, map[string]interface{}{"sub": "tom"})
+
+	for _, tt := range []struct {
+		header string
+		code   int
+	}{
+		{"Bearer " + token, 200},
+		{"bearer " + token, 200},
+		{"", http.StatusUnauthorized},
+		{"Basic dG9tOnNlY3JldA==", http.StatusUnauthorized},
+		{"Bearer " + token + "x", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/api", nil)
+		req.Header.Set("Authorization", tt.header)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, tt.code, w.Code, tt.header)
+		if tt.code == 200 {
+			assert.Equal(t, "tom", w.Body.String())
+		} else {
+			assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		}
+	}
+}
+
+func TestParseJWTOptions(t *testing.T) {
+	keys := newTestKeys(t)
+	dir := t.TempDir()
+	der, err := x509.MarshalPKIXPublicKey(&keys.rsa.PublicKey)
+	assert.Nil(t, err)
+	pemFile := filepath.Join(dir, "rsa.pem")
+	assert.Nil(t, os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(keys.ec.X.Bytes()), "y": encode(keys.ec.Y.Bytes())},
+		{"kty": "oct", "kid": "hs", "k": encode(keys.secret)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+	jwksFile := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, os.WriteFile(jwksFile, jwks, 0600))
+
+	var m interface{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+alg: RS256
+keyfile: `+pemFile+`
+jwks: `+jwksFile+`
+issuer: https://auth.example.com
+audience: api
+skew: 30
+`), &m))
+	options, err := ParseJWTOptions(m)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, options.Keys.Len())
+	assert.Equal(t, "https://auth.example.com", options.Issuer)
+	assert.Equal(t, "api", options.Audience)
+	assert.Equal(t, 30*time.Second, options.Skew)
+	assert.True(t, options.RequireExp)
+
+	claims := map[string]interface{}{"sub": "tom", "iss": "https://auth.example.com", "aud": "api",
+		"exp": time.Now().Add(time.Hour).Unix()}
+	for _, token := range []string{
+		signToken(t, RS256, "", keys.rsa, claims),
+		signToken(t, RS256, "idp-key-1", keys.rsa, claims),
+		signToken(t, ES256, "ec", keys.ec, claims),
+		signToken(t, HS256, "hs", keys.secret, claims),
+	} {
+		_, err := options.Verify(token)
+		assert.Nil(t, err)
+	}
+
+	for _, conf := range []string{
+		"keyfile: " + pemFile,
+		"issuer: https://auth.example.com",
+		"alg: RS256\nkeyfile: " + filepath.Join(dir, "missing.pem"),
+		"jwks: " + pemFile,
+		"jwks: " + jwksFile + "\nskew: soon",
+		"jwks: " + jwksFile + "\nrequireexp: 1",
+	} {
+		assert.Nil(t, yaml.Unmarshal([]byte(conf), &m))
+		_, err := ParseJWTOptions(m)
+		assert.NotNil(t, err, conf)
+	}
+
+	assert.Nil(t, yaml.Unmarshal([]byte("jwks: "+jwksFile+"\nrequireexp: false"), &m))
+	options, err = ParseJWTOptions(m)
+	assert.Nil(t, err)
+	_, err = options.Verify(signToken(t, HS256, "hs", keys.secret, map[string]interface{}{"sub": "tom"}))
+	assert.Nil(t, err, "token without exp allowed")
+}
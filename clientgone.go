@@ -0,0 +1,46 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusClientClosedRequest is the status logged when the client closed the
+// connection before the response is written, it is never sent
+const StatusClientClosedRequest = 499
+
+// isClientGone report whether the recovered panic v is caused by the client
+// closing the connection: broken pipe, connection reset, http.ErrAbortHandler
+// or the cancellation of the request context. The recovery panic again with
+// http.ErrAbortHandler after logging it.
+func isClientGone(c *gin.Context, v interface{}) bool {
+	err, ok := v.(error)
+	if !ok {
+		return false
+	}
+	if errors.Is(err, http.ErrAbortHandler) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) && c.Request.Context().Err() != nil {
+		return true
+	}
+	// the error of some platforms only have the message
+	var se *os.SyscallError
+	if errors.As(err, &se) {
+		msg := strings.ToLower(se.Error())
+		return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientGone(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  interface{}
+		cancel bool
+		gone   bool
+	}{
+		{"broken pipe", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, false, true},
+		{"connection reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, false, true},
+		{"wrapped broken pipe", fmt.Errorf("write response: %w", syscall.EPIPE), false, true},
+		{"wrapped reset", fmt.Errorf("copy: %w", &net.OpError{Err: syscall.ECONNRESET}), false, true},
+		{"message only", &net.OpError{Err: &os.SyscallError{Syscall: "write", Err: errors.New("Broken Pipe")}}, false, true},
+		{"abort handler", http.ErrAbortHandler, false, true},
+		{"wrapped abort handler", fmt.Errorf("proxy: %w", http.ErrAbortHandler), false, true},
+		{"request canceled", fmt.Errorf("query: %w", context.Canceled), true, true},
+		{"canceled by handler", context.Canceled, false, false},
+		{"other syscall", &net.OpError{Err: os.NewSyscallError("write", syscall.EACCES)}, false, false},
+		{"error", errors.New("test only"), false, false},
+		{"string", "broken pipe", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			config := initConfig()
+			config.stdlog = zerolog.New(&stdout).Level(zerolog.DebugLevel)
+			config.errlog = zerolog.New(&stderr)
+			ge := &GinEngine{Engine: gin.New(), config: config}
+			ge.Engine.Use(ginRecovery(config))
+			ge.Engine.GET("/", func(c *gin.Context) {
+				panic(tt.value)
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			w := httptest.NewRecorder()
+			var repanic interface{}
+			func() {
+				defer func() { repanic = recover() }()
+				ge.Engine.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+			}()
+			if err, ok := tt.value.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				assert.Equal(t, http.ErrAbortHandler, repanic, "net/http must abort the connection")
+			} else {
+				assert.Nil(t, repanic)
+			}
+
+			assert.Equal(t, tt.gone, isClientGone(&gin.Context{Request: httptest.NewRequest("GET", "/", nil).WithContext(ctx)}, tt.value))
+			if tt.gone {
+				assert.Equal(t, Stats{ClientGone: 1}, ge.Stats())
+				assert.NotEqual(t, StatusClientClosedRequest, w.Code, "499 is only logged")
+				assert.Empty(t, w.Body.String())
+				assert.Empty(t, stderr.String())
+				assert.Contains(t, stdout.String(), `"level":"debug"`)
+				assert.Contains(t, stdout.String(), "client gone")
+				assert.Contains(t, stdout.String(), `"status":499`)
+			} else {
+				assert.Equal(t, Stats{Panics: 1}, ge.Stats())
+				assert.Equal(t, 500, w.Code)
+				assert.Contains(t, stderr.String(), "[Recovery]")
+			}
+		})
+	}
+}
@@ -8,28 +8,49 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v2"
+
+	"github.com/cytown/gintool/plushgin"
 )
 
 // Config the configuration
 type Config struct {
-	address   string
-	mode      string
-	statics   map[string]string
-	staticFs  map[string]string
-	errors    map[int]string
-	templates string
-	logfile   string
-	errorlog  string
-	certFile  string
-	keyFile   string
-	stdlog    zerolog.Logger
-	errlog    zerolog.Logger
-	other     interface{}
+	address         string
+	mode            string
+	statics         map[string]string
+	staticFs        map[string]string
+	errors          map[int]string
+	templates       string
+	templateRoots   []plushgin.TemplateRoot
+	layout          string
+	templateOverlay bool
+	logfile         string
+	errorlog        string
+	certFile        string
+	keyFile         string
+	stdlog          zerolog.Logger
+	errlog          zerolog.Logger
+	redactor        *Redactor
+	reporters       []ErrorReporter
+	reportersMu     sync.RWMutex
+	stats           stats
+	session         *sessionManager
+	sessionAdmin    string
+	csrf            *CSRFOptions
+	assets          *AssetOptions
+	i18n            *I18nOptions
+	translator      *Translator
+	access          atomic.Pointer[accessRules]
+	raw             interface{}
+	other           interface{}
 }
 
 func initConfig() *Config {
@@ -42,7 +63,8 @@ func initConfig() *Config {
 	}
 }
 
-func parseFile(path string) (*Config, error) {
+// readFile return the gin section of the config file
+func readFile(path string) (interface{}, error) {
 	err := isFile(path)
 	if err != nil {
 		return nil, err
@@ -57,12 +79,17 @@ func parseFile(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	c := initConfig()
 	//fmt.Println("unmarshal: ", out)
-	m, err := extract(out, "gin")
+	return extract(out, "gin")
+}
+
+func parseFile(path string) (*Config, error) {
+	m, err := readFile(path)
 	if err != nil {
 		return nil, err
 	}
+	c := initConfig()
+	c.raw = m
 	mm, err := extract(m, "address")
 	if err == nil {
 		c.address = mm.(string)
@@ -122,14 +149,40 @@ func parseFile(path string) (*Config, error) {
 	}
 	mm, err = extract(m, "templates")
 	if err == nil {
-		ss, ok := mm.(string)
-		if ok && ss != "" {
-			err := isDir(ss)
+		switch ss := mm.(type) {
+		case string:
+			if ss != "" {
+				err := isDir(ss)
+				if err != nil {
+					return nil, err
+				}
+				c.templates = ss
+			}
+		case []interface{}:
+			roots, err := parseTemplateRoots(ss)
 			if err != nil {
 				return nil, err
 			}
-			c.templates = ss
+			c.templateRoots = roots
+		default:
+			return nil, fmt.Errorf("wrong type templates")
+		}
+	}
+	mm, err = extract(m, "templateoverlay")
+	if err == nil {
+		b, ok := mm.(bool)
+		if !ok {
+			return nil, fmt.Errorf("wrong type templateoverlay")
+		}
+		c.templateOverlay = b
+	}
+	mm, err = extract(m, "layout")
+	if err == nil {
+		ss, ok := mm.(string)
+		if !ok {
+			return nil, fmt.Errorf("wrong type layout")
 		}
+		c.layout = ss
 	}
 	mm, err = extract(m, "static")
 	if err == nil {
@@ -169,20 +222,144 @@ func parseFile(path string) (*Config, error) {
 		c.staticFs = static
 		//fmt.Println("staticfile", c.staticFs)
 	}
-	mm, err = extract(m, "error", "404")
-	//fmt.Println("err", err)
+	mm, err = extract(m, "redact")
+	if err == nil {
+		r, err := parseRedactor(mm)
+		if err != nil {
+			return nil, err
+		}
+		c.redactor = r
+	}
+	mm, err = extract(m, "report", "dir")
+	if err == nil {
+		if ss, ok := mm.(string); ok && ss != "" {
+			c.reporters = append(c.reporters, NewFileReporter(ss))
+		}
+	}
+	mm, err = extract(m, "report", "webhook")
+	if err == nil {
+		if ss, ok := mm.(string); ok && ss != "" {
+			c.reporters = append(c.reporters, NewWebhookReporter(ss))
+		}
+	}
+	mm, err = extract(m, "session")
+	if err == nil {
+		o, err := parseSessionOptions(mm)
+		if err != nil {
+			return nil, err
+		}
+		store, err := parseSessionStore(mm, o)
+		if err != nil {
+			return nil, err
+		}
+		c.session, err = newSessionManager(o, store)
+		if err != nil {
+			return nil, err
+		}
+		c.session.gcInterval, err = parseGCInterval(mm)
+		if err != nil {
+			return nil, err
+		}
+		if admin, err := extract(mm, "admin"); err == nil {
+			ss, ok := admin.(string)
+			if !ok || !strings.HasPrefix(ss, "/") {
+				return nil, fmt.Errorf("wrong session admin %v", admin)
+			}
+			c.sessionAdmin = ss
+		}
+	}
+	mm, err = extract(m, "assets")
+	if err == nil {
+		c.assets, err = parseAssetOptions(mm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mm, err = extract(m, "i18n")
+	if err == nil {
+		c.i18n, err = parseI18nOptions(mm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mm, err = extract(m, "csrf")
+	if err == nil {
+		c.csrf, err = parseCSRFOptions(mm, c.session)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mm, err = extract(m, "auth", "rules")
 	if err == nil {
-		c.errors[http.StatusNotFound] = mm.(string)
-		//fmt.Println("404:", mm)
+		rules, err := parseAccessRules(mm)
+		if err != nil {
+			return nil, err
+		}
+		c.access.Store(rules)
 	}
-	mm, err = extract(m, "error", "500")
+	mm, err = extract(m, "error")
 	if err == nil {
-		c.errors[http.StatusInternalServerError] = mm.(string)
-		//fmt.Println("500:", mm)
+		pages, ok := mm.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("wrong type error")
+		}
+		for k, v := range pages {
+			status, err := strconv.Atoi(fmt.Sprint(k))
+			if err != nil || http.StatusText(status) == "" {
+				return nil, fmt.Errorf("wrong error status %v", k)
+			}
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("wrong type error page %v", v)
+			}
+			c.errors[status] = name
+		}
 	}
 	return c, nil
 }
 
+// parseTemplateRoots parse the list of the template directories, an entry is
+// a directory or a mapping of the namespace name and path
+func parseTemplateRoots(list []interface{}) ([]plushgin.TemplateRoot, error) {
+	roots := []plushgin.TemplateRoot{}
+	for _, v := range list {
+		var root plushgin.TemplateRoot
+		switch entry := v.(type) {
+		case string:
+			root.Dir = entry
+		case map[interface{}]interface{}:
+			root.Name, _ = entry["name"].(string)
+			root.Dir, _ = entry["path"].(string)
+			if strings.Contains(root.Name, ":") {
+				return nil, fmt.Errorf("wrong templates name %v", root.Name)
+			}
+		default:
+			return nil, fmt.Errorf("wrong type templates entry %v", v)
+		}
+		if err := isDir(root.Dir); err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+// Redactor return the Redactor used by all the logs, if not configured the
+// default one is used
+func (c *Config) Redactor() *Redactor {
+	if c == nil || c.redactor == nil {
+		return defaultRedactor
+	}
+	return c.redactor
+}
+
+// Section return the raw value of the gin section with key/value mapping,
+// used by the packages which have their own configuration such as auth
+func (c *Config) Section(key ...string) (ret interface{}) {
+	ret, _ = extract(c.raw, key...)
+	return
+}
+
 // Get return the saved other configuration with key/value mapping
 func (c *Config) Get(key ...string) (ret interface{}) {
 	ret, _ = extract(c.other, key...)
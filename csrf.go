@@ -0,0 +1,220 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gobuffalo/plush"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+const csrf_name = "_csrf_token_"
+
+// ErrCSRFToken is the error of the request without a valid CSRF token
+var ErrCSRFToken = errors.New("invalid CSRF token")
+
+// CSRFOptions is the configuration of the CSRF protection
+type CSRFOptions struct {
+	// FieldName is the form field of the token
+	FieldName string
+	// HeaderName is the header of the token, used by the scripts
+	HeaderName string
+	// CookieName is the double submit cookie, the token is kept in the
+	// session if it is empty
+	CookieName string
+	// Secret sign the double submit cookie, so a cookie set by another site
+	// of the domain is rejected. The cookie is never accepted without it.
+	Secret string
+	// Secure set the secure flag of the double submit cookie
+	Secure bool
+	// Exempt is the paths not checked, a path ends with * match the prefix
+	Exempt []string
+}
+
+// DefaultCSRFOptions return the default options which keep the token in the session
+func DefaultCSRFOptions() CSRFOptions {
+	return CSRFOptions{
+		FieldName:  "csrf_token",
+		HeaderName: "X-CSRF-Token",
+	}
+}
+
+func (o *CSRFOptions) exempt(path string) bool {
+	for _, p := range o.Exempt {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// UseCSRF is a middleware which check the CSRF token of the unsafe methods,
+// the request without a valid token get the 403 error page.
+// It must be used after UseSession.
+func UseCSRF(config *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		o := config.csrf
+		if o == nil || o.exempt(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		token := csrfToken(c, o)
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			c.Next()
+			return
+		}
+		sent := c.GetHeader(o.HeaderName)
+		if sent == "" {
+			sent = c.PostForm(o.FieldName)
+		}
+		if sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			config.stdlog.Warn().Msgf("[CSRF] %s %s rejected", c.Request.Method, c.Request.URL.Path)
+			c.Set(configKey, config)
+			ErrorResponse(c, http.StatusForbidden, ErrCSRFToken)
+			return
+		}
+		c.Next()
+	}
+}
+
+// csrfToken return the token of the request, a new one is created if not exist
+func csrfToken(c *gin.Context, o *CSRFOptions) string {
+	s := FromGin(c)
+	var token string
+	if o.CookieName != "" {
+		if cookie, err := c.Request.Cookie(o.CookieName); err == nil {
+			token = verifyCSRFCookie(o, cookie.Value)
+		}
+		if token == "" {
+			token = newSessionID()
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     o.CookieName,
+				Value:    signCSRFCookie(o, token),
+				Path:     "/",
+				Secure:   o.Secure,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	} else if s != nil {
+		token, _ = s.Get(csrf_name).(string)
+		if token == "" {
+			token = newSessionID()
+			s.Set(csrf_name, token)
+		}
+	}
+	c.Set(csrf_name, token)
+	return token
+}
+
+// signCSRFCookie return the value of the double submit cookie, the token with
+// the HMAC of the cookie name and the token
+func signCSRFCookie(o *CSRFOptions, token string) string {
+	mac := hmac.New(sha256.New, []byte(o.Secret))
+	mac.Write([]byte(o.CookieName + "=" + token))
+	return token + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCSRFCookie return the token of the double submit cookie, empty if it
+// is not signed by the secret
+func verifyCSRFCookie(o *CSRFOptions, value string) string {
+	i := strings.LastIndexByte(value, '.')
+	if o.Secret == "" || i < 32 {
+		return ""
+	}
+	token := value[:i]
+	if !hmac.Equal([]byte(value), []byte(signCSRFCookie(o, token))) {
+		return ""
+	}
+	return token
+}
+
+// CSRFToken return the CSRF token of the request, empty if UseCSRF is not used
+func CSRFToken(c *gin.Context) string {
+	return c.GetString(csrf_name)
+}
+
+// addCSRFHelpers register csrfToken() and csrfField() to the templates, the
+// token of the request is set by a context function
+func addCSRFHelpers(p *plushgin.Plush2Render, config *Config) {
+	p.AddContextFunc(func(c *gin.Context) map[string]interface{} {
+		return map[string]interface{}{csrf_name: CSRFToken(c)}
+	})
+	token := func(help plush.HelperContext) string {
+		v, _ := help.Value(csrf_name).(string)
+		return v
+	}
+	p.AddHelper("csrfToken", token)
+	p.AddHelper("csrfField", func(help plush.HelperContext) template.HTML {
+		field := DefaultCSRFOptions().FieldName
+		if config.csrf != nil {
+			field = config.csrf.FieldName
+		}
+		return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+			template.HTMLEscapeString(field), template.HTMLEscapeString(token(help))))
+	})
+}
+
+// parseCSRFOptions read the csrf section, the double submit cookie is used
+// if cookie is set or the session is not configured. The cookie is signed by
+// the secret, or the session secret if not set.
+func parseCSRFOptions(m interface{}, session *sessionManager) (*CSRFOptions, error) {
+	o := DefaultCSRFOptions()
+	for name, value := range map[string]*string{
+		"field":  &o.FieldName,
+		"header": &o.HeaderName,
+		"cookie": &o.CookieName,
+		"secret": &o.Secret,
+	} {
+		mm, err := extract(m, name)
+		if err != nil {
+			continue
+		}
+		ss, ok := mm.(string)
+		if !ok || ss == "" {
+			return nil, fmt.Errorf("wrong type csrf %s", name)
+		}
+		*value = ss
+	}
+	if mm, err := extract(m, "secure"); err == nil {
+		b, ok := mm.(bool)
+		if !ok {
+			return nil, fmt.Errorf("wrong type csrf secure")
+		}
+		o.Secure = b
+	}
+	if mm, err := extract(m, "exempt"); err == nil {
+		list, err := stringList(mm)
+		if err != nil {
+			return nil, err
+		}
+		o.Exempt = list
+	}
+	if o.CookieName == "" && session == nil {
+		o.CookieName = "gintool_csrf"
+	}
+	if o.CookieName != "" && o.Secret == "" {
+		if session == nil || session.options.Secret == "" {
+			return nil, fmt.Errorf("csrf secret is required by the cookie")
+		}
+		o.Secret = session.options.Secret
+	}
+	return &o, nil
+}
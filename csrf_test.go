@@ -0,0 +1,152 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func csrfEngine(t *testing.T, session bool, cookie string) *gin.Engine {
+	config := initConfig()
+	if session {
+		o := DefaultSessionOptions()
+		o.Secret = "test secret"
+		m, err := newSessionManager(o, NewMemoryStore(0))
+		assert.Nil(t, err)
+		config.session = m
+	}
+	o := DefaultCSRFOptions()
+	o.CookieName = cookie
+	o.Secret = "csrf secret"
+	o.Exempt = []string{"/hook", "/api/*"}
+	config.csrf = &o
+	engine := gin.New()
+	render := plushgin.Default()
+	render.Options.TemplateDir = "testdata/templates"
+	addCSRFHelpers(render, config)
+	engine.HTMLRender = render
+	engine.Use(plushgin.UseContext(), UseSession(config), UseCSRF(config))
+	engine.GET("/form", func(c *gin.Context) {
+		c.HTML(200, "csrf.html", gin.H{})
+	})
+	for _, path := range []string{"/form", "/hook", "/api/post"} {
+		engine.POST(path, func(c *gin.Context) {
+			c.String(200, "ok")
+		})
+	}
+	return engine
+}
+
+func postForm(engine *gin.Engine, path string, form url.Values, header http.Header, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "text/plain")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+var csrfFieldRegexp = regexp.MustCompile(`<form><input type="hidden" name="csrf_token" value="([\w-]+)">([\w-]+)</form>`)
+
+func TestCSRF(t *testing.T) {
+	for name, engine := range map[string]*gin.Engine{
+		"session":         csrfEngine(t, true, ""),
+		"cookie":          csrfEngine(t, false, "gintool_csrf"),
+		"session, cookie": csrfEngine(t, true, "gintool_csrf"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := doRequest(engine, "GET", "/form")
+			assert.Equal(t, 200, w.Code)
+			match := csrfFieldRegexp.FindStringSubmatch(w.Body.String())
+			if !assert.NotNil(t, match, w.Body.String()) {
+				return
+			}
+			token := match[1]
+			assert.Equal(t, token, match[2])
+			cookies := w.Result().Cookies()
+			assert.NotEmpty(t, cookies)
+
+			w = postForm(engine, "/form", nil, nil, cookies)
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Equal(t, "403 Forbidden: invalid CSRF token", w.Body.String())
+			w = postForm(engine, "/form", url.Values{"csrf_token": {"wrong"}}, nil, cookies)
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			w = postForm(engine, "/form", url.Values{"csrf_token": {token}}, nil, nil)
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			if name == "cookie" {
+				// a cookie tossed by another site of the domain
+				forged := strings.Repeat("a", 43)
+				for _, value := range []string{forged, forged + ".sig", signCSRFCookie(&CSRFOptions{CookieName: "gintool_csrf", Secret: "other"}, forged)} {
+					cookie := &http.Cookie{Name: "gintool_csrf", Value: value}
+					w = postForm(engine, "/form", url.Values{"csrf_token": {forged}}, nil, []*http.Cookie{cookie})
+					assert.Equal(t, http.StatusForbidden, w.Code, value)
+				}
+			}
+
+			w = postForm(engine, "/form", url.Values{"csrf_token": {token}}, nil, cookies)
+			assert.Equal(t, 200, w.Code)
+			w = postForm(engine, "/form", nil, http.Header{"X-Csrf-Token": {token}}, cookies)
+			assert.Equal(t, 200, w.Code)
+			w = postForm(engine, "/hook", nil, nil, nil)
+			assert.Equal(t, 200, w.Code)
+			w = postForm(engine, "/api/post", nil, nil, nil)
+			assert.Equal(t, 200, w.Code)
+		})
+	}
+}
+
+func TestParseCSRFOptions(t *testing.T) {
+	var m interface{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+field: token
+header: X-Token
+secret: csrf secret
+exempt:
+  - /hook
+  - /api/*
+`), &m))
+	o, err := parseCSRFOptions(m, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "token", o.FieldName)
+	assert.Equal(t, "X-Token", o.HeaderName)
+	assert.Equal(t, "gintool_csrf", o.CookieName)
+	assert.True(t, o.exempt("/hook"))
+	assert.True(t, o.exempt("/api/users"))
+	assert.False(t, o.exempt("/hook/more"))
+
+	assert.Equal(t, "csrf secret", o.Secret)
+
+	o, err = parseCSRFOptions(m, &sessionManager{})
+	assert.Nil(t, err)
+	assert.Empty(t, o.CookieName)
+
+	assert.Nil(t, yaml.Unmarshal([]byte("cookie: csrf"), &m))
+	o, err = parseCSRFOptions(m, &sessionManager{options: SessionOptions{Secret: "session secret"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "session secret", o.Secret, "the session secret sign the cookie")
+	_, err = parseCSRFOptions(m, nil)
+	assert.NotNil(t, err, "secret required by the cookie")
+
+	assert.Nil(t, yaml.Unmarshal([]byte("field: 3"), &m))
+	_, err = parseCSRFOptions(m, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-errors/errors"
+)
+
+// debugSourceLines is the count of lines shown before and after each frame
+const debugSourceLines = 5
+
+// templateNamer is implemented by the errors of plushgin when render failed
+type templateNamer interface {
+	TemplateName() string
+}
+
+type debugLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+type debugFrame struct {
+	Function string
+	File     string
+	Line     int
+	Source   []debugLine
+}
+
+type debugPage struct {
+	Status    int
+	Title     string
+	Error     string
+	Type      string
+	Method    string
+	URL       string
+	RequestID string
+	Template  string
+	Frames    []debugFrame
+	Headers   map[string][]string
+	Params    gin.Params
+	Session   map[string]string
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>{{.Status}} {{.Title}}</title>
+<style>
+body{font-family:sans-serif;margin:0;color:#222}
+header{background:#c0392b;color:#fff;padding:16px 24px}
+header h1{margin:0;font-size:20px}header p{margin:8px 0 0;font-family:monospace;white-space:pre-wrap}
+section{padding:8px 24px}h2{font-size:16px;border-bottom:1px solid #ddd}
+table{border-collapse:collapse;font-family:monospace;font-size:13px}td{padding:2px 8px;vertical-align:top}
+.frame{margin-bottom:12px}.frame b{font-family:monospace}.file{color:#777;font-family:monospace;font-size:12px}
+pre{margin:4px 0;background:#f7f7f7;font-size:12px}pre span{display:block}pre .current{background:#fdd}
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Status}} {{.Title}} - {{.Type}}</h1>
+<p>{{.Error}}</p>
+</header>
+<section>
+<table>
+<tr><td>Request</td><td>{{.Method}} {{.URL}}</td></tr>
+<tr><td>Request ID</td><td>{{.RequestID}}</td></tr>
+{{if .Template}}<tr><td>Template</td><td>{{.Template}}</td></tr>{{end}}
+</table>
+</section>
+<section>
+<h2>Stack</h2>
+{{range .Frames}}<div class="frame"><b>{{.Function}}</b>
+<div class="file">{{.File}}:{{.Line}}</div>
+{{if .Source}}<pre>{{range .Source}}<span{{if .Current}} class="current"{{end}}>{{printf "%5d" .Number}}  {{.Text}}</span>{{end}}</pre>{{end}}
+</div>
+{{end}}
+</section>
+<section>
+<h2>Route Params</h2>
+<table>{{range .Params}}<tr><td>{{.Key}}</td><td>{{.Value}}</td></tr>{{else}}<tr><td>none</td></tr>{{end}}</table>
+</section>
+<section>
+<h2>Session</h2>
+<table>{{range $k, $v := .Session}}<tr><td>{{$k}}</td><td>{{$v}}</td></tr>{{else}}<tr><td>none</td></tr>{{end}}</table>
+</section>
+<section>
+<h2>Headers</h2>
+<table>{{range $k, $v := .Headers}}<tr><td>{{$k}}</td><td>{{range $v}}{{.}} {{end}}</td></tr>{{end}}</table>
+</section>
+</body>
+</html>
+`))
+
+// renderDebugPage write the developer error page of the panic, it must only be
+// used in debug mode because the page show the source code and the session
+func renderDebugPage(c *gin.Context, config *Config, status int, stack *errors.Error) {
+	c.Abort()
+	if c.Writer.Written() {
+		return
+	}
+	redactor := config.Redactor()
+	page := &debugPage{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Error:     stack.Error(),
+		Type:      stack.TypeName(),
+		Method:    c.Request.Method,
+		URL:       redactor.URL(c.Request.URL),
+		RequestID: RequestID(c),
+		Headers:   redactor.Header(c.Request.Header),
+		Params:    c.Params,
+		Session:   map[string]string{},
+	}
+	var namer templateNamer
+	if errors.As(stack, &namer) {
+		page.Template = namer.TemplateName()
+	}
+	for _, frame := range stack.StackFrames() {
+		page.Frames = append(page.Frames, debugFrame{
+			Function: frame.Package + "." + frame.Name,
+			File:     frame.File,
+			Line:     frame.LineNumber,
+			Source:   sourceContext(frame.File, frame.LineNumber, debugSourceLines),
+		})
+	}
+	if s := FromGin(c); s != nil {
+		for _, k := range s.Keys() {
+			page.Session[k] = fmt.Sprintf("%v", s.Get(k))
+		}
+	}
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := debugTemplate.Execute(c.Writer, page); err != nil {
+		config.errlog.Error().Msgf("[Recovery] debug page: %v", err)
+	}
+}
+
+// sourceContext return the lines around line of file, nil if file is unreadable
+func sourceContext(file string, line int, around int) []debugLine {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var ret []debugLine
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan() && n <= line+around; n++ {
+		if n >= line-around {
+			ret = append(ret, debugLine{Number: n, Text: scanner.Text(), Current: n == line})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func TestDebugPage(t *testing.T) {
+	config := initConfig()
+	config.errors[500] = "error/500.html"
+	engine := gin.New()
+	render := plushgin.Default()
+	render.Options.TemplateDir = "testdata/templates"
+	engine.HTMLRender = render
+	engine.Use(UseRequestID(), ginRecovery(config), UseSession(config))
+	engine.GET("/user/:id", func(c *gin.Context) {
+		SessionSet("lang", "fr")
+		panic("debug only")
+	})
+	engine.GET("/render", func(c *gin.Context) {
+		c.HTML(200, "missing.html", gin.H{})
+	})
+	html500, _ := os.ReadFile("testdata/templates/error/500.html")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Authorization", "Bearer "+"hidden"+"value")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	w := get("/user/42")
+	assert.Equal(t, 500, w.Code)
+	assert.Equal(t, string(html500), w.Body.String())
+
+	gin.SetMode(gin.DebugMode)
+	w = get("/user/42")
+	body := w.Body.String()
+	assert.Equal(t, 500, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, body, "debug only")
+	assert.Contains(t, body, `panic(&#34;debug only&#34;)`, "source of the frame")
+	assert.Contains(t, body, `class="current"`)
+	assert.Contains(t, body, "<td>id</td><td>42</td>")
+	assert.Contains(t, body, "<td>lang</td><td>fr</td>")
+	assert.Contains(t, body, w.Header().Get(RequestIDHeader))
+	assert.NotContains(t, body, "hiddenvalue")
+
+	w = get("/render")
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "<td>Template</td><td>missing.html</td>")
+}
+
+func TestSourceContext(t *testing.T) {
+	lines := sourceContext("testdata/static/test.txt", 1, 5)
+	assert.Equal(t, []debugLine{{Number: 1, Text: "hello world", Current: true}}, lines)
+	assert.Nil(t, sourceContext("testdata/not-exist", 1, 5))
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,7 +14,6 @@ import (
 	"syscall"
 
 	"github.com/gin-gonic/gin"
-	"github.com/v2pro/plz/gls"
 
 	"github.com/cytown/gintool"
 )
@@ -36,15 +36,18 @@ func main() {
 	log.Println("ginengine: ", ge)
 	ge.Engine.Use(func(c *gin.Context) {
 		defer func() {
-			log.Println("test middle ware end", gls.GoID())
+			log.Println("test middle ware end")
 		}()
 		//log.Println("test middle ware start")
-		gintool.SessionSet("test", "great")
-		log.Println("test middle ware start2", gls.GoID(), gintool.SessionGet("test"))
+		gintool.FromGin(c).Set("test", "great")
+		log.Println("test middle ware start2", gintool.FromGin(c).Get("test"))
 		c.Next()
 	})
 	ge.Engine.GET("/", func(c *gin.Context) {
-		log.Println("session key test", gls.GoID(), gintool.SessionGet("test"))
+		log.Println("session key test", gintool.FromGin(c).Get("test"))
+		gintool.Go(c, func(ctx context.Context) {
+			log.Println("session key in goroutine", gintool.FromContext(ctx).Get("test"))
+		})
 		panic("test")
 	})
 	err = ge.Start()
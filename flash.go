@@ -0,0 +1,77 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// FlashKind is the kind of the flash message
+type FlashKind string
+
+// The kinds of flash message
+const (
+	FlashSuccess FlashKind = "success"
+	FlashInfo    FlashKind = "info"
+	FlashWarning FlashKind = "warning"
+	FlashError   FlashKind = "error"
+)
+
+// String return the kind as string, so it can be shown in the templates
+func (k FlashKind) String() string {
+	return string(k)
+}
+
+const flash_name = "_flashes_"
+
+// FlashMessage is a one-shot message stored in the session until it is read
+type FlashMessage struct {
+	Kind    FlashKind
+	Message string
+}
+
+func init() {
+	registerType([]FlashMessage{})
+}
+
+// Flash add a message to the session, it is removed by the next read of
+// Flashes or the next HTML render of a template in a request, even if the
+// template doesn't print the flashes variable. The error pages and the
+// renders whose data has flashes keep the messages.
+func Flash(c *gin.Context, kind FlashKind, msg string) {
+	if s := FromGin(c); s != nil {
+		s.AddFlash(kind, msg)
+	}
+}
+
+// Flashes return and remove all the flash messages of the session
+func Flashes(c *gin.Context) []FlashMessage {
+	return FromGin(c).Flashes()
+}
+
+// AddFlash add a flash message to the session
+func (s *Session) AddFlash(kind FlashKind, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes, _ := s.data[flash_name].([]FlashMessage)
+	s.data[flash_name] = append(flashes, FlashMessage{Kind: kind, Message: msg})
+	s.modified = true
+}
+
+// Flashes return and remove all the flash messages of the session
+func (s *Session) Flashes() []FlashMessage {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes, ok := s.data[flash_name].([]FlashMessage)
+	if !ok {
+		return nil
+	}
+	delete(s.data, flash_name)
+	s.modified = true
+	return flashes
+}
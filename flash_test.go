@@ -0,0 +1,81 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func TestFlash(t *testing.T) {
+	engine := sessionEngine(t, NewMemoryStore(0))
+	render := plushgin.Default()
+	render.Options.TemplateDir = "testdata/templates"
+	config := initConfig()
+	config.errors = map[int]string{404: "flash.html"}
+	addContextValues(render, config)
+	engine.HTMLRender = render
+	engine.Use(plushgin.UseContext())
+	engine.POST("/flash", func(c *gin.Context) {
+		Flash(c, FlashSuccess, "saved")
+		Flash(c, FlashWarning, "check it")
+		c.Redirect(http.StatusSeeOther, "/flashes")
+	})
+	engine.GET("/flashes", func(c *gin.Context) {
+		var kinds []string
+		for _, f := range Flashes(c) {
+			kinds = append(kinds, string(f.Kind)+":"+f.Message)
+		}
+		c.String(200, strings.Join(kinds, ","))
+	})
+	engine.GET("/flash.html", func(c *gin.Context) {
+		c.HTML(200, "flash.html", gin.H{})
+	})
+	engine.GET("/flash-data", func(c *gin.Context) {
+		c.HTML(200, "flash.html", gin.H{"flashes": []FlashMessage{{Kind: FlashInfo, Message: "data"}}})
+	})
+	engine.GET("/flash-error", func(c *gin.Context) {
+		c.Set(configKey, config)
+		c.Request.Header.Set("Accept", "text/html")
+		ErrorResponse(c, 404, nil)
+	})
+
+	w := doRequest(engine, "POST", "/flash")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	cookie := sessionCookie(w)
+	assert.NotNil(t, cookie)
+	w = doRequest(engine, "GET", "/flashes", cookie)
+	assert.Equal(t, "success:saved,warning:check it", w.Body.String())
+	w = doRequest(engine, "GET", "/flashes", cookie)
+	assert.Equal(t, "", w.Body.String())
+
+	doRequest(engine, "POST", "/flash", cookie)
+	w = doRequest(engine, "GET", "/flash-data", cookie)
+	assert.Equal(t, `<p class="info">data</p>`, strings.TrimSpace(w.Body.String()))
+	w = doRequest(engine, "GET", "/flash-error", cookie)
+	assert.Equal(t, 404, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Equal(t, "", strings.TrimSpace(w.Body.String()), "the error pages keep the flashes")
+	w = doRequest(engine, "GET", "/flash.html", cookie)
+	assert.Equal(t, `<p class="success">saved</p><p class="warning">check it</p>`, strings.TrimSpace(w.Body.String()))
+	w = doRequest(engine, "GET", "/flash.html", cookie)
+	assert.Equal(t, "", strings.TrimSpace(w.Body.String()))
+}
+
+func TestFlashWithoutSession(t *testing.T) {
+	c, _ := gin.CreateTestContext(nil)
+	Flash(c, FlashError, "lost")
+	assert.Nil(t, Flashes(c))
+	s := newSession()
+	s.AddFlash(FlashInfo, "hello")
+	assert.Equal(t, []FlashMessage{{Kind: FlashInfo, Message: "hello"}}, s.Flashes())
+	assert.Nil(t, s.Flashes())
+}
@@ -8,12 +8,10 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net"
+	"io/fs"
 	"net/http"
-	"net/http/httputil"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/gin-contrib/logger"
 	"github.com/gin-gonic/gin"
@@ -30,7 +28,9 @@ type GinEngine struct {
 	Engine   *gin.Engine
 	server   *http.Server
 	template *plushgin.Plush2Render
+	assets   *AssetManager
 	config   *Config
+	path     string
 }
 
 //var stdlog = zlog.Output(os.Stdout)
@@ -48,17 +48,46 @@ func NewGin(path string) (*GinEngine, error) {
 		return nil, e
 	}
 
+	mode := gin.Mode()
 	gin.SetMode(gin.ReleaseMode)
 	engine := gin.New()
-	gin.SetMode(gin.DebugMode)
+	gin.SetMode(mode)
 
 	ge := &GinEngine{
 		Engine: engine,
 		config: c,
+		path:   path,
 	}
-	fmt.Println("===", c)
 
 	ge.template = plushgin.Default()
+	addContextValues(ge.template, c)
+	addCSRFHelpers(ge.template, c)
+	if c.templates != "" {
+		ge.template.Options.TemplateDir = c.templates
+	}
+	if len(c.templateRoots) > 0 {
+		// the list of templates replace the default directory
+		ge.template.Options.TemplateDir = ""
+		ge.template.Options.Roots = c.templateRoots
+	}
+	if c.assets != nil {
+		assets, err := NewAssetManager(c.statics, *c.assets)
+		if err != nil {
+			return nil, err
+		}
+		ge.assets = assets
+		addAssetHelpers(ge.template, assets)
+	}
+	if c.i18n != nil {
+		translator, err := NewTranslator(*c.i18n)
+		if err != nil {
+			return nil, err
+		}
+		c.translator = translator
+		addI18nHelpers(ge.template, translator)
+	}
+	ge.template.Options.Layout = c.layout
+	engine.HTMLRender = ge.template
 	gin.ForceConsoleColor()
 	var logs io.Writer
 	var logfile *os.File
@@ -126,17 +155,123 @@ func NewGin(path string) (*GinEngine, error) {
 		c.errlog.Level(zerolog.DebugLevel)
 	}
 
+	engine.Use(plushgin.UseContext())
+	engine.Use(UseRequestID())
 	engine.Use(logger.SetLogger(logger.WithUTC(true), logger.WithLogger(func(cc *gin.Context, logger zerolog.Logger) zerolog.Logger {
-		return c.stdlog
+		l := c.stdlog.With().Str("request_id", RequestID(cc))
+		if cc.Request.URL.RawQuery != "" {
+			l = l.Str("query", c.Redactor().Query(cc.Request.URL.RawQuery))
+		}
+		return l.Logger()
 	})))
-	engine.Use(ginRecovery(c.errors, c))
+	engine.Use(ginRecovery(c))
+	engine.Use(UseAccessRules(c))
+	if ge.assets != nil {
+		engine.Use(ge.assets.Handler())
+	}
 	engine.Use(UseSession(c))
+	if c.translator != nil {
+		engine.Use(UseI18n(c))
+	}
+	if c.csrf != nil {
+		engine.Use(UseCSRF(c))
+	}
+	if c.sessionAdmin != "" {
+		if err := ge.MountSessionAdmin(c.sessionAdmin); err != nil {
+			return nil, err
+		}
+	}
 	return ge, nil
 }
 
-// AddTemplates to add templates with the specified name
+// addContextValues register the variables of the templates: config, the
+// function of Config.Get, and for the requests request, path, session and
+// flashes. The flash messages are only removed from the session if the data
+// doesn't have flashes.
+func addContextValues(p *plushgin.Plush2Render, config *Config) {
+	p.AddGlobal("config", config.Get)
+	p.AddGlobal("flashes", []FlashMessage(nil))
+	p.AddContextFunc(func(c *gin.Context) map[string]interface{} {
+		s := FromGin(c)
+		return map[string]interface{}{
+			"request": c.Request,
+			"path":    c.Request.URL.Path,
+			"session": s,
+			"flashes": plushgin.LazyValue(func() interface{} { return s.Flashes() }),
+		}
+	})
+}
+
+// Config return the configuration parsed from gin.conf
+func (ge *GinEngine) Config() *Config {
+	return ge.config
+}
+
+// SetTemplateFS load the templates from fsys such as an embed.FS, the names of
+// the templates are relative to its root. With templateoverlay: true in
+// gin.conf, the files in the templates directory override the files of fsys.
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	sub, _ := fs.Sub(templates, "templates")
+//	ge.SetTemplateFS(sub)
+func (ge *GinEngine) SetTemplateFS(fsys fs.FS) {
+	ge.template.Options.FS = fsys
+	ge.template.Options.Overlay = ge.config.templateOverlay
+}
+
+// Assets return the fingerprinted static files, nil if assets is not
+// configured in gin.conf
+func (ge *GinEngine) Assets() *AssetManager {
+	return ge.assets
+}
+
+// Translator return the message catalogs, nil if i18n is not configured in
+// gin.conf
+func (ge *GinEngine) Translator() *Translator {
+	return ge.config.translator
+}
+
+// RenderToString render the template outside of a request such as a report,
+// the content type of the template is inferred from its extension
+func (ge *GinEngine) RenderToString(name string, data gin.H) (string, error) {
+	return ge.template.RenderToString(name, data)
+}
+
+// RenderEmail render the email of the templates name.txt and name.html, for
+// example:
+//
+//	email, err := ge.RenderEmail("mail/welcome", gin.H{"user": user})
+//	email.From, email.To, email.Subject = "noreply@example.com", []string{user.Email}, "Welcome"
+//	msg, err := email.Bytes()
+//	err = smtp.SendMail(addr, auth, "noreply@example.com", email.To, msg)
+func (ge *GinEngine) RenderEmail(name string, data gin.H) (*plushgin.Email, error) {
+	return ge.template.RenderEmail(name, data)
+}
+
+// AddHelper register a helper function usable in all the templates
+func (ge *GinEngine) AddHelper(name string, f interface{}) {
+	ge.template.AddHelper(name, f)
+}
+
+// AddGlobal register a variable of all the templates
+func (ge *GinEngine) AddGlobal(name string, value interface{}) {
+	ge.template.AddGlobal(name, value)
+}
+
+// AddContextFunc register f to add the variables of the templates rendered
+// in a request, such as the current user
+func (ge *GinEngine) AddContextFunc(f plushgin.ContextFunc) {
+	ge.template.AddContextFunc(f)
+}
+
+// AddTemplates to add templates with the specified name, the last file is
+// rendered in the layouts of the other files from the outermost:
+//
+//	ge.AddTemplates("admin/users", "layouts/base.html", "layouts/admin.html", "admin/users.html")
 func (ge *GinEngine) AddTemplates(name string, files ...string) {
-	//ge.template.Options..AddFromFiles(name, files...)
+	ge.template.AddTemplate(name, files...)
 }
 
 func resetDefault() {
@@ -192,18 +327,26 @@ func (ge *GinEngine) Start() (ret error) {
 	if c.templates != "" {
 		ge.template.Options.TemplateDir = c.templates
 	}
+	// the syntax errors of the templates fail the start in release mode
+	if gin.Mode() == gin.ReleaseMode {
+		if err := ge.template.Precompile(); err != nil {
+			return err
+		}
+	}
 
 	// add error handling
 	//for key, value := range c.errors {
 	//	ge.AddTemplates(errorName(key), value)
 	//}
 	c.stdlog.Debug().Msgf("errors : %s %v", ge.config.errors[http.StatusNotFound], c.errors)
-	if _, ok := ge.config.errors[http.StatusNotFound]; ok {
-		ge.Engine.NoRoute(func(c *gin.Context) {
-			c.HTML(http.StatusNotFound, ge.config.errors[http.StatusNotFound], gin.H{})
-		})
-	}
-	ge.Engine.NoMethod()
+	ge.Engine.NoRoute(func(cc *gin.Context) {
+		cc.Set(configKey, c)
+		ErrorResponse(cc, http.StatusNotFound, nil)
+	})
+	ge.Engine.NoMethod(func(cc *gin.Context) {
+		cc.Set(configKey, c)
+		ErrorResponse(cc, http.StatusMethodNotAllowed, nil)
+	})
 	ge.Engine.HTMLRender = ge.template
 
 	c.stdlog.Info().Msgf("| starting gin server |")
@@ -220,6 +363,9 @@ func (ge *GinEngine) Start() (ret error) {
 	if c.templates != "" {
 		c.stdlog.Info().Msgf("| templates: %s", c.templates)
 	}
+	for i := range c.templateRoots {
+		c.stdlog.Info().Msgf("| templates: %s", c.templateRoots[i].String())
+	}
 	if len(c.statics) > 0 {
 		c.stdlog.Info().Msgf("| statics : %v", c.statics)
 	}
@@ -240,6 +386,9 @@ func (ge *GinEngine) Start() (ret error) {
 	c.stdlog.Info().Msgf("Listening and serving %s on %s\n", runtype, address)
 	server := http.Server{Addr: address, Handler: ge.Engine}
 
+	if c.session != nil {
+		defer c.session.startGC(c)()
+	}
 	defer func() {
 		ge.server = nil
 	}()
@@ -255,64 +404,83 @@ func (ge *GinEngine) Start() (ret error) {
 	return
 }
 
-func ginRecovery(errors map[int]string, cc *Config) gin.HandlerFunc {
-	return recoveryWithWriter(cc, func(c *gin.Context) {
-		if v, ok := errors[c.Writer.Status()]; ok {
-			c.HTML(c.Writer.Status(), v, gin.H{
-				"errors": errors,
-			})
+func ginRecovery(cc *Config) gin.HandlerFunc {
+	return recoveryWithWriter(cc, func(c *gin.Context, err error) {
+		var stack *errors.Error
+		// only browsers get the debug page, other clients keep the configured response
+		if gin.Mode() == gin.DebugMode && errors.As(err, &stack) && IsBrowser(c) {
+			renderDebugPage(c, cc, http.StatusInternalServerError, stack)
+			return
 		}
+		ErrorResponse(c, http.StatusInternalServerError, err)
 	})
 }
 
-func recoveryWithWriter(config *Config, f func(c *gin.Context)) gin.HandlerFunc {
+func recoveryWithWriter(config *Config, f func(c *gin.Context, err error)) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		c.Set(configKey, config)
 		defer func() {
 			if err := recover(); err != nil {
 				stack := errors.Wrap(err, 2)
-				status := http.StatusInternalServerError
-				// Check for a broken connection, as it is not really a
+				// A client which closed the connection is not really a
 				// condition that warrants a panic stack trace.
-				var brokenPipe bool
-				if ne, ok := err.(*net.OpError); ok {
-					if se, ok := ne.Err.(*os.SyscallError); ok {
-						if strings.Contains(strings.ToLower(se.Error()), "broken pipe") || strings.Contains(strings.ToLower(se.Error()), "connection reset by peer") {
-							brokenPipe = true
-							err = errors.New("[broken pipe]")
-							status = http.StatusGatewayTimeout
-						}
+				// http.ErrAbortHandler is panicked again so net/http abort
+				// the connection instead of finishing the response.
+				if isClientGone(c, err) {
+					config.stats.clientGone.Add(1)
+					config.stdlog.Debug().Int("status", StatusClientClosedRequest).
+						Msgf("[Recovery] client gone when visit %s: %v", c.Request.URL.Path, err)
+					c.Abort()
+					if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+						panic(http.ErrAbortHandler)
 					}
-				}
-
-				_ = c.AbortWithError(status, stack)
-
-				if brokenPipe {
 					return
 				}
+				config.stats.panics.Add(1)
+				_ = c.Error(stack)
+				c.Abort()
 
-				httpRequest, _ := httputil.DumpRequest(c.Request, false)
-				headers := strings.Split(string(httpRequest), "\r\n")
-				for idx, header := range headers {
-					current := strings.Split(header, ":")
-					if current[0] == "Authorization" {
-						headers[idx] = current[0] + ": *"
-					}
-				}
-				h := strings.Join(headers, "\n")
+				redactor := config.Redactor()
+				h := redactor.DumpRequest(c.Request)
 				config.errlog.Info().Msgf("errors when visit: %s", c.Request.URL.Path)
 				if gin.IsDebugging() {
 					config.errlog.Error().Msgf("[Recovery] panic recovered:\n%s", h)
+					if body, ok := c.Get(gin.BodyBytesKey); ok {
+						if buf, ok := body.([]byte); ok {
+							config.errlog.Error().Msgf("[Recovery] body:\n%s", redactor.JSON(buf))
+						}
+					}
 					config.errlog.Error().Msgf("[Recovery] [%s]\n%s", err, stack.Stack())
 				} else {
 					config.errlog.Error().Msgf("[Recovery] panic recovered:\n[%s] %s\n%s",
 						c.Request.URL.Path, err, stack.Stack())
 				}
+				config.report(newPanicReport(c, config, stack))
 
-				f(c)
+				f(c, stack)
 			}
 		}()
 		c.Next() // execute all the handlers
+		if err := renderFailed(c); err != nil {
+			config.errlog.Error().Msgf("[Render] %s: %v", c.Request.URL.Path, err)
+			f(c, errors.Wrap(err, 0))
+		}
+	}
+}
+
+// renderFailed return the error of the template failed to render if nothing
+// is written, the 500 response is not sent by the renderer
+func renderFailed(c *gin.Context) error {
+	if c.Writer.Written() {
+		return nil
+	}
+	for _, e := range c.Errors {
+		var namer templateNamer
+		if errors.As(e.Err, &namer) {
+			return e.Err
+		}
 	}
+	return nil
 }
 
 // HandleSession will create a new session with key map to store the value for future use.
@@ -8,7 +8,9 @@ import (
 	"crypto/tls"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,6 +19,12 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// rawConfig return the gin section of the file, nil if it can't be read
+func rawConfig(path string) interface{} {
+	m, _ := readFile(path)
+	return m
+}
+
 func TestNewGin(t *testing.T) {
 	type args struct {
 		path string
@@ -48,6 +56,7 @@ func TestNewGin(t *testing.T) {
 					},
 					logfile:  "log/gin.log",
 					errorlog: "log/gin.log",
+					raw:      rawConfig("config/gin.conf"),
 				},
 			},
 			wantErr: false,
@@ -80,6 +89,7 @@ func TestNewGin(t *testing.T) {
 					other: map[interface{}]interface{}{
 						"hello": "world",
 					},
+					raw: rawConfig("testdata/gin.conf"),
 				},
 			},
 			wantErr: false,
@@ -215,7 +225,7 @@ func TestGinEngine_Start(t *testing.T) {
 			[]resp{
 				{
 					404,
-					"404 page not found",
+					"404 Not Found",
 				},
 			},
 		},
@@ -229,7 +239,7 @@ func TestGinEngine_Start(t *testing.T) {
 				},
 			},
 			func(g *GinEngine) {
-				g.Engine.Use(ginRecovery(map[int]string{}, g.config))
+				g.Engine.Use(ginRecovery(g.config))
 				g.Engine.GET("/", func(c *gin.Context) {
 					panic("test only")
 				})
@@ -238,7 +248,7 @@ func TestGinEngine_Start(t *testing.T) {
 			[]resp{
 				{
 					500,
-					"",
+					"500 Internal Server Error: test only",
 				},
 			},
 		},
@@ -293,6 +303,9 @@ func TestGinEngine_Start(t *testing.T) {
 	}
 	t.Run("config file test", func(t *testing.T) {
 		g, _ := NewGin("testdata/test.conf")
+		// the browser get the debug page in debug mode
+		gin.SetMode(gin.ReleaseMode)
+		defer gin.SetMode(gin.DebugMode)
 		t1 := time.Now()
 		g.HandleSession("GET", "/", func(c *gin.Context) {
 			config := SessionConfig()
@@ -318,7 +331,9 @@ func TestGinEngine_Start(t *testing.T) {
 		}
 		client := &http.Client{Transport: tr}
 		//_, err := client.Get("https://golang.org/")
-		res, _ := client.Get(url)
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", "text/html")
+		res, _ := client.Do(req)
 		resp, _ := io.ReadAll(res.Body)
 		want, _ := os.ReadFile("testdata/templates/error/500.html")
 		assert.Equal(t, 500, res.StatusCode)
@@ -327,3 +342,24 @@ func TestGinEngine_Start(t *testing.T) {
 		g.config.stdlog.Printf("shutdown: %v", g.ShutDown())
 	})
 }
+
+func TestContextValues(t *testing.T) {
+	ge, err := NewGin("testdata/gin.conf")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	ge.AddGlobal("site", "gintool")
+	ge.Engine.GET("/context", func(c *gin.Context) {
+		Flash(c, FlashInfo, "hi")
+		assert.NotNil(t, FromGin(c))
+		c.HTML(200, "context.html", nil)
+	})
+
+	w := httptest.NewRecorder()
+	ge.Engine.ServeHTTP(w, httptest.NewRequest("GET", "/context", nil))
+	assert.Equal(t, "gintool GET /context world  info:hi", strings.TrimSpace(w.Body.String()))
+
+	out, err := ge.RenderToString("context.html", gin.H{"request": &http.Request{Method: "MAIL"}, "path": "/mail"})
+	assert.Nil(t, err)
+	assert.Equal(t, "gintool MAIL /mail world", strings.TrimSpace(out))
+}
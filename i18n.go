@@ -0,0 +1,430 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gobuffalo/plush"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+const (
+	locale_name = "_locale_"
+	i18n_name   = "_i18n_"
+)
+
+// I18nOptions is the configuration of the translations
+type I18nOptions struct {
+	// Dir is the directory of the message catalogs, one file per locale
+	// such as en.yaml, fr.json or pt-BR.yml
+	Dir string
+	// Default is the locale used when no locale of the request is available
+	Default string
+	// Param is the query parameter choosing the locale, the choice is kept
+	// in the session or in Cookie if the session is not configured
+	Param string
+	// Cookie is the name of the cookie keeping the locale
+	Cookie string
+}
+
+// DefaultI18nOptions return the default options with the messages in locales
+func DefaultI18nOptions() I18nOptions {
+	return I18nOptions{
+		Dir:     "locales",
+		Default: "en",
+		Param:   "lang",
+		Cookie:  "lang",
+	}
+}
+
+// message is a translation, a plural message has one form per category
+type message struct {
+	text   string
+	plural map[string]string
+}
+
+// Translator keep the message catalogs of the locales
+type Translator struct {
+	options  I18nOptions
+	locales  []string
+	catalogs map[string]map[string]*message
+}
+
+// NewTranslator load the message catalogs of options.Dir. The nested keys
+// are joined with dots, and a mapping of plural categories (zero, one, two,
+// few, many, other) is a plural message selected by the count argument:
+//
+//	greeting: Hello, {name}!
+//	inbox:
+//	  title: Inbox
+//	  messages:
+//	    one: You have {count} message
+//	    other: You have {count} messages
+func NewTranslator(options I18nOptions) (*Translator, error) {
+	t := &Translator{options: options, catalogs: map[string]map[string]*message{}}
+	files, err := os.ReadDir(options.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		locale := strings.TrimSuffix(f.Name(), ext)
+		if _, ok := t.catalogs[locale]; ok {
+			return nil, fmt.Errorf("duplicated catalog of %s", locale)
+		}
+		catalog, err := readCatalog(filepath.Join(options.Dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t.catalogs[locale] = catalog
+		t.locales = append(t.locales, locale)
+	}
+	sort.Strings(t.locales)
+	if t.options.Default == "" {
+		t.options.Default = DefaultI18nOptions().Default
+	}
+	if t.locale(t.options.Default) == "" {
+		return nil, fmt.Errorf("no catalog of the default locale %s in %s", t.options.Default, options.Dir)
+	}
+	return t, nil
+}
+
+func readCatalog(file string) (map[string]*message, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if filepath.Ext(file) == ".json" {
+		err = json.Unmarshal(buf, &out)
+	} else {
+		err = yaml.Unmarshal(buf, &out)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("wrong catalog %s: %w", file, err)
+	}
+	catalog := map[string]*message{}
+	if err := flattenCatalog(catalog, "", out); err != nil {
+		return nil, fmt.Errorf("wrong catalog %s: %w", file, err)
+	}
+	return catalog, nil
+}
+
+// flattenCatalog add the messages of v to catalog with the keys joined by dots
+func flattenCatalog(catalog map[string]*message, prefix string, v interface{}) error {
+	entries := map[string]interface{}{}
+	switch m := v.(type) {
+	case nil:
+		return nil
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			entries[fmt.Sprint(k)] = v
+		}
+	case map[string]interface{}:
+		entries = m
+	default:
+		if prefix == "" {
+			return fmt.Errorf("not a mapping")
+		}
+		catalog[prefix] = &message{text: fmt.Sprint(v)}
+		return nil
+	}
+	if prefix != "" && isPlural(entries) {
+		msg := &message{plural: map[string]string{}}
+		for k, v := range entries {
+			msg.plural[k] = fmt.Sprint(v)
+		}
+		catalog[prefix] = msg
+		return nil
+	}
+	for k, v := range entries {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if err := flattenCatalog(catalog, key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPlural return true if all the keys are plural categories with other
+func isPlural(entries map[string]interface{}) bool {
+	if _, ok := entries["other"]; !ok {
+		return false
+	}
+	for k, v := range entries {
+		switch v.(type) {
+		case map[interface{}]interface{}, map[string]interface{}:
+			return false
+		}
+		if !pluralCategories[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// Locales return the locales having a catalog
+func (t *Translator) Locales() []string {
+	return append([]string(nil), t.locales...)
+}
+
+// Default return the default locale
+func (t *Translator) Default() string {
+	return t.options.Default
+}
+
+// locale return the available locale matching l, or its language such as fr
+// for fr-CA, empty if none
+func (t *Translator) locale(l string) string {
+	l = strings.ReplaceAll(strings.TrimSpace(l), "_", "-")
+	if l == "" {
+		return ""
+	}
+	for _, locale := range t.locales {
+		if strings.EqualFold(locale, l) {
+			return locale
+		}
+	}
+	if i := strings.Index(l, "-"); i > 0 {
+		return t.locale(l[:i])
+	}
+	return ""
+}
+
+// Translate return the message of key in locale, then in its language and
+// the default locale, or key itself if not found. The {name} placeholders
+// are replaced by the args, and the count of args select the plural form.
+func (t *Translator) Translate(locale, key string, args map[string]interface{}) string {
+	for _, l := range []string{locale, t.options.Default} {
+		for l != "" {
+			if msg, ok := t.catalogs[t.locale(l)][key]; ok {
+				return interpolate(msg.format(l, args["count"]), args)
+			}
+			i := strings.LastIndex(l, "-")
+			if i <= 0 {
+				break
+			}
+			l = l[:i]
+		}
+	}
+	return key
+}
+
+// format return the text of the message, the plural form of count
+func (m *message) format(locale string, count interface{}) string {
+	if m.plural == nil {
+		return m.text
+	}
+	n, ok := pluralCount(count)
+	if !ok {
+		return m.plural["other"]
+	}
+	if n == 0 {
+		if text, ok := m.plural["zero"]; ok {
+			return text
+		}
+	}
+	if text, ok := m.plural[pluralRuleOf(locale)(abs(n))]; ok {
+		return text
+	}
+	return m.plural["other"]
+}
+
+// interpolate replace the {name} placeholders of text by args, the unknown
+// placeholders are kept
+func interpolate(text string, args map[string]interface{}) string {
+	if len(args) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(text, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start:], "}")
+		if end < 0 {
+			break
+		}
+		name := text[start+1 : start+end]
+		b.WriteString(text[:start])
+		if v, ok := args[name]; ok {
+			b.WriteString(fmt.Sprint(v))
+		} else {
+			b.WriteString(text[start : start+end+1])
+		}
+		text = text[start+end+1:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// Negotiate return the locale of the request, from the query parameter, the
+// session or the cookie, then Accept-Language, then the default locale
+func (t *Translator) Negotiate(c *gin.Context) string {
+	if t.options.Param != "" {
+		if l := t.locale(c.Query(t.options.Param)); l != "" {
+			return l
+		}
+	}
+	if s := FromGin(c); s != nil {
+		if l, ok := s.Get(locale_name).(string); ok {
+			if l = t.locale(l); l != "" {
+				return l
+			}
+		}
+	}
+	if t.options.Cookie != "" {
+		if v, err := c.Cookie(t.options.Cookie); err == nil {
+			if l := t.locale(v); l != "" {
+				return l
+			}
+		}
+	}
+	for _, l := range acceptLanguages(c.GetHeader("Accept-Language")) {
+		if l = t.locale(l); l != "" {
+			return l
+		}
+	}
+	return t.options.Default
+}
+
+// acceptLanguages return the languages of Accept-Language by preference
+func acceptLanguages(header string) []string {
+	type language struct {
+		tag string
+		q   float64
+	}
+	var languages []language
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if f = strings.TrimSpace(f); strings.HasPrefix(f, "q=") {
+				if n, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = n
+				}
+			}
+		}
+		if q > 0 {
+			languages = append(languages, language{tag, q})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].q > languages[j].q
+	})
+	ret := make([]string, len(languages))
+	for i, l := range languages {
+		ret[i] = l.tag
+	}
+	return ret
+}
+
+// UseI18n is a middleware to negotiate the locale of the request, the
+// locale chosen by the query parameter is kept in the session if configured,
+// or in the cookie. It must be used after UseSession.
+func UseI18n(config *Config) gin.HandlerFunc {
+	t := config.translator
+	return func(c *gin.Context) {
+		locale := t.Negotiate(c)
+		if t.options.Param != "" && c.Query(t.options.Param) != "" && t.locale(c.Query(t.options.Param)) == locale {
+			if s := FromGin(c); s != nil && config.session != nil {
+				s.Set(locale_name, locale)
+			} else if t.options.Cookie != "" {
+				http.SetCookie(c.Writer, &http.Cookie{
+					Name:     t.options.Cookie,
+					Value:    locale,
+					Path:     "/",
+					MaxAge:   365 * 24 * 3600,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+		}
+		c.Set(i18n_name, t)
+		c.Set(locale_name, locale)
+		c.Header("Content-Language", locale)
+		c.Next()
+	}
+}
+
+// Locale return the locale of the request negotiated by UseI18n, empty if
+// i18n is not configured
+func Locale(c *gin.Context) string {
+	return c.GetString(locale_name)
+}
+
+// T return the message of key in the locale of the request, see
+// Translator.Translate. The key is returned if i18n is not configured.
+func T(c *gin.Context, key string, args map[string]interface{}) string {
+	t, ok := c.Value(i18n_name).(*Translator)
+	if !ok {
+		return interpolate(key, args)
+	}
+	return t.Translate(Locale(c), key, args)
+}
+
+// addI18nHelpers register the locale variable and the t helper of the
+// templates, the locale of the request is set by a context function and
+// also select the variants of the templates such as index.fr.html
+func addI18nHelpers(p *plushgin.Plush2Render, t *Translator) {
+	p.AddGlobal(plushgin.LocaleKey, t.Default())
+	p.AddContextFunc(func(c *gin.Context) map[string]interface{} {
+		if l := Locale(c); l != "" {
+			return map[string]interface{}{plushgin.LocaleKey: l}
+		}
+		return nil
+	})
+	p.AddHelper("t", func(key string, args map[string]interface{}, help plush.HelperContext) string {
+		locale, _ := help.Value(plushgin.LocaleKey).(string)
+		return t.Translate(locale, key, args)
+	})
+}
+
+// parseI18nOptions parse the i18n section of gin.conf
+func parseI18nOptions(m interface{}) (*I18nOptions, error) {
+	o := DefaultI18nOptions()
+	for name, value := range map[string]*string{
+		"dir":     &o.Dir,
+		"default": &o.Default,
+		"param":   &o.Param,
+		"cookie":  &o.Cookie,
+	} {
+		mm, err := extract(m, name)
+		if err != nil {
+			continue
+		}
+		ss, ok := mm.(string)
+		if !ok {
+			return nil, fmt.Errorf("wrong type i18n %s", name)
+		}
+		*value = ss
+	}
+	if err := isDir(o.Dir); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
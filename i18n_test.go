@@ -0,0 +1,165 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func testTranslator(t *testing.T) *Translator {
+	o := DefaultI18nOptions()
+	o.Dir = "testdata/locales"
+	tr, err := NewTranslator(o)
+	assert.Nil(t, err)
+	return tr
+}
+
+func TestTranslate(t *testing.T) {
+	tr := testTranslator(t)
+	assert.Equal(t, []string{"en", "fr", "ru"}, tr.Locales())
+
+	tests := []struct {
+		locale string
+		key    string
+		args   map[string]interface{}
+		want   string
+	}{
+		{"en", "greeting", map[string]interface{}{"name": "Tom"}, "Hello, Tom!"},
+		{"fr", "greeting", map[string]interface{}{"name": "Tom"}, "Bonjour, Tom !"},
+		{"fr-CA", "greeting", map[string]interface{}{"name": "Tom"}, "Bonjour, Tom !"},
+		{"fr", "greeting", nil, "Bonjour, {name} !"},
+		{"fr", "inbox.title", nil, "Boîte de réception"},
+		{"fr", "only_en", nil, "English only"},
+		{"de", "greeting", map[string]interface{}{"name": "Tom"}, "Hello, Tom!"},
+		{"en", "missing.key", nil, "missing.key"},
+		{"en", "inbox.messages", map[string]interface{}{"count": 0}, "No message"},
+		{"en", "inbox.messages", map[string]interface{}{"count": 1}, "You have 1 message"},
+		{"en", "inbox.messages", map[string]interface{}{"count": 2}, "You have 2 messages"},
+		{"en", "inbox.messages", map[string]interface{}{"count": 1.5}, "You have 1.5 messages"},
+		{"fr", "inbox.messages", map[string]interface{}{"count": 0}, "Vous avez 0 message"},
+		{"fr", "inbox.messages", map[string]interface{}{"count": 2}, "Vous avez 2 messages"},
+		{"ru", "inbox.messages", map[string]interface{}{"count": 21}, "21 сообщение"},
+		{"ru", "inbox.messages", map[string]interface{}{"count": 3}, "3 сообщения"},
+		{"ru", "inbox.messages", map[string]interface{}{"count": 11}, "11 сообщений"},
+		{"ru", "inbox.messages", map[string]interface{}{"count": int64(25)}, "25 сообщений"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tr.Translate(tt.locale, tt.key, tt.args), tt.locale+" "+tt.key)
+	}
+
+	o := DefaultI18nOptions()
+	o.Dir = "testdata/locales"
+	o.Default = "de"
+	_, err := NewTranslator(o)
+	assert.NotNil(t, err)
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "en.yaml"), []byte("- a list"), 0600))
+	o.Dir, o.Default = dir, "en"
+	_, err = NewTranslator(o)
+	assert.NotNil(t, err)
+}
+
+func TestPluralRules(t *testing.T) {
+	for locale, want := range map[string][]string{
+		"en": {"other", "one", "other", "other", "other", "other"},
+		"fr": {"one", "one", "other", "other", "other", "other"},
+		"ja": {"other", "other", "other", "other", "other", "other"},
+		"pl": {"many", "one", "few", "many", "many", "few"},
+		"cs": {"other", "one", "few", "other", "other", "other"},
+		"ar": {"zero", "one", "two", "few", "many", "other"},
+	} {
+		rule := pluralRuleOf(locale)
+		for i, n := range []int64{0, 1, 2, 5, 12, 102} {
+			assert.Equal(t, want[i], rule(n), "%s %d", locale, n)
+		}
+	}
+}
+
+func TestAcceptLanguages(t *testing.T) {
+	assert.Equal(t, []string{"fr-CA", "en-US", "en"},
+		acceptLanguages("en;q=0.5, fr-CA, *;q=0.1, en-US;q=0.8, de;q=0"))
+	assert.Empty(t, acceptLanguages(""))
+}
+
+func TestI18n(t *testing.T) {
+	config := initConfig()
+	config.translator = testTranslator(t)
+	render := plushgin.Default()
+	render.Options.TemplateDir = "testdata/templates"
+	addI18nHelpers(render, config.translator)
+	engine := gin.New()
+	engine.HTMLRender = render
+	engine.Use(plushgin.UseContext(), UseSession(config), UseI18n(config))
+	engine.GET("/", func(c *gin.Context) {
+		c.String(200, Locale(c)+"|"+T(c, "inbox.messages", gin.H{"count": 3}))
+	})
+	engine.GET("/page", func(c *gin.Context) {
+		c.HTML(200, "i18n/index.html", gin.H{"name": "Tom"})
+	})
+	get := func(path, accept, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Language", accept)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, "en|You have 3 messages", get("/", "", "").Body.String())
+	assert.Equal(t, "fr|Vous avez 3 messages", get("/", "de, fr-CH;q=0.9", "").Body.String())
+	assert.Equal(t, "ru|3 сообщения", get("/", "fr", "lang=ru").Body.String())
+	w := get("/?lang=fr", "ru", "lang=ru")
+	assert.Equal(t, "fr|Vous avez 3 messages", w.Body.String())
+	assert.Equal(t, "fr", w.Header().Get("Content-Language"))
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "lang=fr")
+	w = get("/?lang=xx", "ru", "")
+	assert.Equal(t, "ru|3 сообщения", w.Body.String())
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+
+	assert.Equal(t, "Hello, Tom!|en", get("/page", "", "").Body.String())
+	assert.Equal(t, "fr: Bonjour, Tom !|Vous avez 1 message", get("/page", "fr-CA", "").Body.String())
+	assert.Equal(t, "Hello, Tom!|ru", get("/page", "ru", "").Body.String())
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Equal(t, "", Locale(c))
+	assert.Equal(t, "a 1", T(c, "a {n}", gin.H{"n": 1}))
+}
+
+func TestI18nSession(t *testing.T) {
+	config := initConfig()
+	o := DefaultSessionOptions()
+	o.Secret = "test secret"
+	m, err := newSessionManager(o, NewMemoryStore(10))
+	assert.Nil(t, err)
+	config.session = m
+	config.translator = testTranslator(t)
+	engine := gin.New()
+	engine.Use(UseSession(config), UseI18n(config))
+	engine.GET("/", func(c *gin.Context) {
+		c.String(200, Locale(c))
+	})
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/?lang=ru", nil))
+	assert.Equal(t, "ru", w.Body.String())
+	session := w.Result().Cookies()[0]
+	assert.NotEqual(t, "lang", session.Name, "the locale is kept in the session")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(session)
+	req.Header.Set("Accept-Language", "fr")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, "ru", w.Body.String())
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"math"
+	"strings"
+)
+
+// the plural categories of CLDR
+var pluralCategories = map[string]bool{
+	"zero": true, "one": true, "two": true, "few": true, "many": true, "other": true,
+}
+
+// pluralRule return the plural category of the integer n
+type pluralRule func(n int64) string
+
+// pluralRules are the cardinal rules of CLDR for the integers, the languages
+// not listed use the rule of English
+var pluralRules = map[string]pluralRule{
+	"ja": pluralOther, "zh": pluralOther, "ko": pluralOther, "th": pluralOther,
+	"vi": pluralOther, "id": pluralOther, "ms": pluralOther,
+	"fr": pluralZeroOne, "pt": pluralZeroOne, "hi": pluralZeroOne,
+	"ru": pluralSlavic, "uk": pluralSlavic, "be": pluralSlavic,
+	"sr": pluralSlavic, "hr": pluralSlavic, "bs": pluralSlavic,
+	"pl": pluralPolish,
+	"cs": pluralCzech, "sk": pluralCzech,
+	"ar": pluralArabic,
+}
+
+func pluralOne(n int64) string {
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+func pluralOther(int64) string {
+	return "other"
+}
+
+func pluralZeroOne(n int64) string {
+	if n == 0 || n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+func pluralSlavic(n int64) string {
+	switch mod10, mod100 := n%10, n%100; {
+	case mod10 == 1 && mod100 != 11:
+		return "one"
+	case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+		return "few"
+	}
+	return "many"
+}
+
+func pluralPolish(n int64) string {
+	switch mod10, mod100 := n%10, n%100; {
+	case n == 1:
+		return "one"
+	case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+		return "few"
+	}
+	return "many"
+}
+
+func pluralCzech(n int64) string {
+	switch {
+	case n == 1:
+		return "one"
+	case n >= 2 && n <= 4:
+		return "few"
+	}
+	return "other"
+}
+
+func pluralArabic(n int64) string {
+	switch mod100 := n % 100; {
+	case n == 0:
+		return "zero"
+	case n == 1:
+		return "one"
+	case n == 2:
+		return "two"
+	case mod100 >= 3 && mod100 <= 10:
+		return "few"
+	case mod100 >= 11:
+		return "many"
+	}
+	return "other"
+}
+
+// pluralRuleOf return the rule of the language of locale
+func pluralRuleOf(locale string) pluralRule {
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		lang = lang[:i]
+	}
+	if rule, ok := pluralRules[lang]; ok {
+		return rule
+	}
+	return pluralOne
+}
+
+// pluralCount return the count as an integer, false if it is not a number
+// or not an integer
+func pluralCount(count interface{}) (int64, bool) {
+	switch n := count.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case float32:
+		return pluralCount(float64(n))
+	case float64:
+		if n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// abs return the absolute value of n for the plural rules
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
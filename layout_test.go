@@ -0,0 +1,66 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func TestLayouts(t *testing.T) {
+	render := plushgin.Default()
+	render.Options.TemplateDir = "testdata/templates"
+	render.Options.Layout = "layouts/base.html"
+	ge := &GinEngine{Engine: gin.New(), template: render, config: initConfig()}
+	ge.Engine.HTMLRender = render
+	ge.Engine.Use(ginRecovery(ge.config))
+	ge.AddTemplates("admin/page", "layouts/base.html", "layouts/section.html", "page.html")
+
+	tests := []struct {
+		name     string
+		template string
+		data     gin.H
+		want     string
+	}{
+		{"default layout", "page.html", gin.H{"name": "tom"},
+			"<html><title>Page</title><body><p>tom</p></body></html>"},
+		{"no layout", "page.html", gin.H{"name": "tom", "layout": false},
+			"<p>tom</p>"},
+		{"empty layout", "page.html", gin.H{"name": "tom", "layout": ""},
+			"<p>tom</p>"},
+		{"nested layout", "page.html", gin.H{"name": "tom", "layout": "layouts/admin.html"},
+			"<html><title>Page</title><body><nav>admin</nav><p>tom</p></body></html>"},
+		{"named template", "admin/page", gin.H{"name": "tom"},
+			"<html><title>Page</title><body><section><p>tom</p></section></body></html>"},
+		{"content of default", "flash.html", gin.H{"flashes": []FlashMessage{}},
+			"<html><title>default</title><body>\n</body></html>"},
+		{"layout loop", "page.html", gin.H{"name": "tom", "layout": "layouts/loop.html"},
+			"500 Internal Server Error"},
+	}
+	for idx, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := fmt.Sprintf("/%d", idx)
+			ge.Engine.GET(path, func(c *gin.Context) {
+				c.HTML(200, tt.template, tt.data)
+			})
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Accept", "text/plain")
+			w := httptest.NewRecorder()
+			ge.Engine.ServeHTTP(w, req)
+			if w.Code == 500 {
+				assert.True(t, strings.HasPrefix(w.Body.String(), tt.want), w.Body.String())
+				return
+			}
+			assert.Equal(t, tt.want, w.Body.String())
+		})
+	}
+}
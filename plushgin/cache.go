@@ -6,12 +6,18 @@
 package plushgin
 
 import (
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/plush"
 	lru "github.com/hashicorp/golang-lru"
 )
 
 // Some package-internal structure helping to implement render cache
 type templateCache struct {
-	cache *lru.Cache
+	cache   *lru.Cache
+	lookups sync.Map
+	missing sync.Map
 }
 
 func newTemplateCache(max int) *templateCache {
@@ -22,15 +28,45 @@ func newTemplateCache(max int) *templateCache {
 	}
 }
 
-func (c *templateCache) Get(templateName string) []byte {
-	rendered, alreadyInCache := c.cache.Get(templateName)
+// cachedTemplate is the parsed template and the time of its file
+type cachedTemplate struct {
+	template *plush.Template
+	file     string
+	modTime  time.Time
+	size     int64
+}
+
+func (c *templateCache) Get(templateName string) *cachedTemplate {
+	parsed, alreadyInCache := c.cache.Get(templateName)
 
 	if alreadyInCache {
-		return rendered.([]byte)
+		return parsed.(*cachedTemplate)
 	}
 	return nil
 }
 
-func (c *templateCache) Add(templateName string, content []byte) {
-	c.cache.Add(templateName, content)
+func (c *templateCache) Add(templateName string, parsed *cachedTemplate) {
+	c.cache.Add(templateName, parsed)
+}
+
+// Lookup return the root found for the template
+func (c *templateCache) Lookup(templateName string) *TemplateRoot {
+	if root, ok := c.lookups.Load(templateName); ok {
+		return root.(*TemplateRoot)
+	}
+	return nil
+}
+
+func (c *templateCache) AddLookup(templateName string, root *TemplateRoot) {
+	c.lookups.Store(templateName, root)
+}
+
+// IsMissing return true if the template is known to not exist
+func (c *templateCache) IsMissing(templateName string) bool {
+	_, ok := c.missing.Load(templateName)
+	return ok
+}
+
+func (c *templateCache) AddMissing(templateName string) {
+	c.missing.Store(templateName, true)
 }
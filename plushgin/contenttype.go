@@ -0,0 +1,168 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gobuffalo/plush"
+)
+
+// DefaultContentTypes are the content types of the extensions of the
+// templates, the .plush extension is skipped such as report.json.plush. The
+// .html templates use RenderOptions.ContentType.
+var DefaultContentTypes = map[string]string{
+	".txt":  "text/plain; charset=utf-8",
+	".text": "text/plain; charset=utf-8",
+	".xml":  "application/xml; charset=utf-8",
+	".rss":  "application/rss+xml; charset=utf-8",
+	".atom": "application/atom+xml; charset=utf-8",
+	".svg":  "image/svg+xml",
+	".json": "application/json; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".csv":  "text/csv; charset=utf-8",
+	".ics":  "text/calendar; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+}
+
+// templateExt return the extension of the template without .plush
+func templateExt(name string) string {
+	_, file := splitNamespace(name)
+	file = strings.TrimSuffix(file, ".plush")
+	return strings.ToLower(path.Ext(file))
+}
+
+// contentType return the content type of the template by its extension,
+// RenderOptions.ContentType for .html and the unknown extensions
+func (p *Plush2Render) contentType(name string) string {
+	ext := templateExt(name)
+	if ct, ok := p.Options.ContentTypes[ext]; ok {
+		return ct
+	}
+	switch ext {
+	case "", ".html", ".htm", ".plush":
+		return p.Options.ContentType
+	}
+	if ct, ok := DefaultContentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return p.Options.ContentType
+}
+
+// the escaping of the values of the templates by content type
+const (
+	escapeHTML = ""
+	escapeJS   = "js"
+	escapeCSS  = "css"
+	escapeText = "text"
+)
+
+// escaping return how the values of the content type are escaped: as HTML
+// for HTML and XML, as the strings of JavaScript for JSON and JavaScript, as
+// CSS for CSS, and they are not escaped for the text
+func escaping(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "" || strings.Contains(mediaType, "html") || strings.Contains(mediaType, "xml"):
+		return escapeHTML
+	case strings.Contains(mediaType, "json") || strings.Contains(mediaType, "javascript") || strings.Contains(mediaType, "ecmascript"):
+		return escapeJS
+	case mediaType == "text/css":
+		return escapeCSS
+	}
+	return escapeText
+}
+
+// escapeHelper escape v for the content type of the template being rendered.
+// plush always escape the strings of <%= %> as HTML, the templates which are
+// not HTML write their values with escape:
+//
+//	{"name": "<%= escape(name) %>"}
+func (p *Plush2Render) escapeHelper(v interface{}, help plush.HelperContext) template.HTML {
+	if t, ok := v.(time.Time); ok {
+		// formatted as plush does
+		format, ok := help.Value("TIME_FORMAT").(string)
+		if !ok {
+			format = plush.DefaultTimeFormat
+		}
+		v = t.Format(format)
+	}
+	name, _ := help.Value(templateKey).(string)
+	return escapeValue(escaping(p.contentType(name)), v)
+}
+
+// escapeValue escape v for the mode, the template.HTML such as the partials
+// is kept
+func escapeValue(mode string, v interface{}) template.HTML {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case template.HTML:
+		return t
+	case string:
+		return template.HTML(escapeString(mode, t))
+	case fmt.Stringer:
+		return template.HTML(escapeString(mode, t.String()))
+	case []string:
+		var b strings.Builder
+		for _, s := range t {
+			b.WriteString(escapeString(mode, s))
+		}
+		return template.HTML(b.String())
+	case []interface{}:
+		var b strings.Builder
+		for _, e := range t {
+			b.WriteString(string(escapeValue(mode, e)))
+		}
+		return template.HTML(b.String())
+	}
+	return template.HTML(escapeString(mode, fmt.Sprint(v)))
+}
+
+func escapeString(mode, s string) string {
+	switch mode {
+	case escapeHTML:
+		return template.HTMLEscapeString(s)
+	case escapeJS:
+		return jsString(s)
+	case escapeCSS:
+		return cssString(s)
+	}
+	return s
+}
+
+// jsString escape s as the content of a string of JSON or JavaScript, < > &
+// are escaped so the value can't close a script element
+func jsString(s string) string {
+	buf, _ := json.Marshal(s)
+	s = string(buf[1 : len(buf)-1])
+	return strings.ReplaceAll(s, "'", `\u0027`)
+}
+
+// cssString escape the characters of s which are not letters or digits as
+// the hexadecimal escapes of CSS
+func cssString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf && !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' && r != '_' && r != '.' {
+			fmt.Fprintf(&b, "\\%x ", r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
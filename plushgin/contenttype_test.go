@@ -0,0 +1,112 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentTypes(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"page.html":         "<p><%= name %></p>",
+		"note.txt":          "Dear <%= escape(name) %> & co",
+		"feed.xml":          "<name><%= escape(name) %></name>",
+		"report.json.plush": `{"name": <%= toJSON(name) %>}`,
+		"event.ics":         "BEGIN:VCALENDAR\nSUMMARY:<%= escape(name) %>\nEND:VCALENDAR",
+		"card.vcf":          "FN:<%= escape(name) %>",
+		"layout.html":       "[<%= yield %>]",
+	})
+	p := Default()
+	p.Options.TemplateDir = dir
+	p.Options.Layout = "layout.html"
+	p.Options.ContentTypes = map[string]string{".vcf": "text/vcard; charset=utf-8"}
+	p.AddTemplate("named/report", "report.json.plush")
+	engine := gin.New()
+	engine.HTMLRender = p
+	engine.GET("/*name", func(c *gin.Context) {
+		c.HTML(200, c.Param("name")[1:], gin.H{"name": `O'Brien <ob@example.com>`})
+	})
+
+	for name, want := range map[string][2]string{
+		"page.html":         {"text/html; charset=utf-8", "[<p>O&#39;Brien &lt;ob@example.com&gt;</p>]"},
+		"note.txt":          {"text/plain; charset=utf-8", "Dear O'Brien <ob@example.com> & co"},
+		"feed.xml":          {"application/xml; charset=utf-8", "<name>O&#39;Brien &lt;ob@example.com&gt;</name>"},
+		"report.json.plush": {"application/json; charset=utf-8", `{"name": "O'Brien \u003cob@example.com\u003e"}`},
+		"named/report":      {"application/json; charset=utf-8", `{"name": "O'Brien \u003cob@example.com\u003e"}`},
+		"event.ics":         {"text/calendar; charset=utf-8", "BEGIN:VCALENDAR\nSUMMARY:O'Brien <ob@example.com>\nEND:VCALENDAR"},
+		"card.vcf":          {"text/vcard; charset=utf-8", "FN:O'Brien <ob@example.com>"},
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("GET", "/"+name, nil))
+		assert.Equal(t, want[0], w.Header().Get("Content-Type"), name)
+		assert.Equal(t, want[1], w.Body.String(), name)
+	}
+
+	out, err := p.RenderToString("note.txt", map[string]interface{}{"name": "Tom", "layout": "layout.html"})
+	assert.Nil(t, err)
+	assert.Equal(t, "[Dear Tom & co]", out, "the layout of the data is used")
+	_, err = p.RenderToString("missing.txt", nil)
+	assert.NotNil(t, err)
+}
+
+func TestContentTypeEscaping(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"user.json":  `{"name": "<%= escape(name) %>", "id": <%= escape(id) %>, "tags": [<%= for (i, tag) in tags { %><%= if (i > 0) { %>, <% } %>"<%= escape(tag) %>"<% } %>]}`,
+		"init.js":    `var user = {name: '<%= escape(name) %>', id: <%= id %>}; // &amp; is kept`,
+		"theme.css":  `.user::after { content: "<%= escape(name) %>"; }`,
+		"note.txt":   `<%= escape(name) %> &amp; <%= escape(partial("sign.txt")) %> <%= escape(id) %> <%= escape(admin) %>`,
+		"_sign.txt":  `-- <%= escape(name) %>`,
+		"page.html":  `<p><%= escape(name) %> &amp;</p>`,
+		"quote.json": `{"text": "<%= escape("a \"%>\" b") %>"}`,
+	})
+	p := Default()
+	p.Options.TemplateDir = dir
+	name := `x", "admin": true, "y": "</script><script>alert('x')</script>`
+	data := map[string]interface{}{"name": name, "id": 7, "admin": false, "tags": []string{`a"b`, "c"}}
+
+	for file, want := range map[string]string{
+		"user.json": `{"name": "x\", \"admin\": true, \"y\": \"\u003c/script\u003e\u003cscript\u003ealert(\u0027x\u0027)\u003c/script\u003e", "id": 7, "tags": ["a\"b", "c"]}`,
+		"init.js":   `var user = {name: 'x\", \"admin\": true, \"y\": \"\u003c/script\u003e\u003cscript\u003ealert(\u0027x\u0027)\u003c/script\u003e', id: 7}; // &amp; is kept`,
+		"theme.css": `.user::after { content: "x\22 \2c  \22 admin\22 \3a  true\2c  \22 y\22 \3a  \22 \3c \2f script\3e \3c script\3e alert\28 \27 x\27 \29 \3c \2f script\3e "; }`,
+		"note.txt":  name + ` &amp; -- ` + name + ` 7 false`,
+		"page.html": `<p>x&#34;, &#34;admin&#34;: true, &#34;y&#34;: &#34;&lt;/script&gt;&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt; &amp;</p>`,
+	} {
+		out, err := p.RenderToString(file, data)
+		assert.Nil(t, err, file)
+		assert.Equal(t, want, out, file)
+	}
+
+	out, err := p.RenderToString("user.json", data)
+	assert.Nil(t, err)
+	var decoded map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(out), &decoded))
+	assert.Equal(t, name, decoded["name"])
+	assert.Nil(t, decoded["admin"])
+
+	out, err = p.RenderToString("quote.json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"text": "a \"%\u003e\" b"}`, out)
+}
+
+func TestContentTypeErrorLine(t *testing.T) {
+	// the tag of name spans two lines
+	source := "{\n\"name\": \"<%= escape(\n  name) %>\",\n\"id\": <%= escape(missing(id)) %>\n}"
+	dir := writeTemplates(t, map[string]string{"user.json": source, "user.html": source})
+	p := Default()
+	p.Options.TemplateDir = dir
+	for _, name := range []string{"user.json", "user.html"} {
+		_, err := p.RenderToString(name, map[string]interface{}{"name": "tom", "id": 7})
+		var e *TemplateExecError
+		if assert.True(t, errors.As(err, &e), name) {
+			assert.Equal(t, 4, e.Line, name)
+		}
+	}
+}
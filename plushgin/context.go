@@ -6,15 +6,86 @@
 package plushgin
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gobuffalo/plush"
 )
 
-// NewContext create a plush.Context
+// ContextFunc return the variables of the templates for the request of c
+type ContextFunc func(c *gin.Context) map[string]interface{}
+
+// LazyValue is a variable of a ContextFunc computed only if the data of the
+// render doesn't have the same name, such as the flash messages which are
+// removed from the session when read
+type LazyValue func() interface{}
+
+// NewContext create a plush.Context, the variables of data override the
+// globals
 func NewContext(p *Plush2Render, c gin.H) plush.Context {
-	pc := *plush.NewContextWith(c)
+	data := make(map[string]interface{}, len(c)+len(p.globals)+len(p.helpers))
+	for name, v := range p.globals {
+		data[name] = v
+	}
+	for name, v := range c {
+		data[name] = v
+	}
+	pc := *plush.NewContextWith(data)
 	for fn, f := range p.helpers {
 		pc.Set(fn, f)
 	}
 	return pc
 }
+
+// contextWriter carry the gin context to Render, which only receive the
+// writer of the response
+type contextWriter struct {
+	gin.ResponseWriter
+	c *gin.Context
+}
+
+// Unwrap return the original writer
+func (w *contextWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// UseContext is a middleware to give the gin context to the functions added
+// by AddContextFunc when the templates are rendered
+func UseContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer = &contextWriter{ResponseWriter: c.Writer, c: c}
+		c.Next()
+	}
+}
+
+// ginContext return the gin context of the writer wrapped by UseContext, the
+// writers wrapping it must have an Unwrap method
+func ginContext(w http.ResponseWriter) *gin.Context {
+	for w != nil {
+		if cw, ok := w.(*contextWriter); ok {
+			return cw.c
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.Unwrap()
+	}
+	return nil
+}
+
+// setContext set the variables of the context functions for c, unless the
+// data has the same name, the LazyValue are called only for the variables set
+func (p *Plush2Render) setContext(c *gin.Context) {
+	for _, f := range p.contextFuncs {
+		for name, v := range f(c) {
+			if _, ok := p.data[name]; ok {
+				continue
+			}
+			if lazy, ok := v.(LazyValue); ok {
+				v = lazy()
+			}
+			p.Context.Set(name, v)
+		}
+	}
+}
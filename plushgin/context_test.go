@@ -0,0 +1,100 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// wrapWriter is a writer of another middleware wrapping the one of UseContext
+type wrapWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *wrapWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestContextFunc(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"page.html":  "<%= site %>|<%= path %>|<%= user %>|<%= partial(\"_nav.html\") %>",
+		"_nav.html":  "nav:<%= path %>",
+		"plain.html": "<%= site %>|<%= user %>",
+	})
+	p := Default()
+	p.Options.TemplateDir = dir
+	p.AddGlobal("site", "gintool")
+	p.AddGlobal("user", "guest")
+	p.AddContextFunc(func(c *gin.Context) map[string]interface{} {
+		return map[string]interface{}{"path": c.Request.URL.Path, "user": c.Query("user")}
+	})
+
+	engine := gin.New()
+	engine.HTMLRender = p
+	engine.Use(UseContext(), func(c *gin.Context) {
+		c.Writer = &wrapWriter{c.Writer}
+		c.Next()
+	})
+	engine.GET("/page", func(c *gin.Context) {
+		c.HTML(200, "page.html", nil)
+	})
+	engine.GET("/data", func(c *gin.Context) {
+		c.HTML(200, "page.html", gin.H{"user": "data", "site": "mine"})
+	})
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Body.String()
+	}
+	assert.Equal(t, "gintool|/page|tom|nav:/page", get("/page?user=tom"))
+	assert.Equal(t, "mine|/data|data|nav:/data", get("/data?user=tom"), "the data override the globals and the context")
+
+	out, err := p.RenderToString("plain.html", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "gintool|guest", out, "no context outside a request")
+
+	engine = gin.New()
+	engine.HTMLRender = p
+	engine.GET("/plain", func(c *gin.Context) {
+		c.HTML(200, "plain.html", nil)
+	})
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest("GET", "/plain?user=tom", nil))
+	assert.Equal(t, "gintool|guest", w.Body.String(), "no context without UseContext")
+}
+
+func TestLazyValue(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{"page.html": "<%= count %>"})
+	p := Default()
+	p.Options.TemplateDir = dir
+	calls := 0
+	p.AddContextFunc(func(c *gin.Context) map[string]interface{} {
+		return map[string]interface{}{"count": LazyValue(func() interface{} {
+			calls++
+			return calls
+		})}
+	})
+	engine := gin.New()
+	engine.HTMLRender = p
+	engine.Use(UseContext())
+	engine.GET("/", func(c *gin.Context) {
+		c.HTML(200, "page.html", nil)
+	})
+	engine.GET("/data", func(c *gin.Context) {
+		c.HTML(200, "page.html", gin.H{"count": 0})
+	})
+	for path, want := range map[string]string{"/": "1", "/data": "0"} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, want, w.Body.String(), path)
+	}
+	assert.Equal(t, 1, calls, "not called if the data has the name")
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Email is a message of a text and/or an HTML body, sent as
+// multipart/alternative if it has both
+type Email struct {
+	From    string
+	To      []string
+	Cc      []string
+	Subject string
+	Text    string
+	HTML    string
+	// Header is the extra headers such as Reply-To
+	Header map[string]string
+}
+
+// RenderEmail render the text and the HTML bodies of the email name, such as
+// welcome.txt and welcome.html for welcome, at least one of them must exist.
+// The default layout is not used unless the data has a layout.
+func (p *Plush2Render) RenderEmail(name string, data map[string]interface{}) (*Email, error) {
+	email := &Email{}
+	found := false
+	for _, part := range []struct {
+		ext  string
+		body *string
+	}{{".txt", &email.Text}, {".html", &email.HTML}} {
+		file := name + part.ext
+		if !p.exists(file) {
+			continue
+		}
+		found = true
+		values := map[string]interface{}{"layout": ""}
+		for k, v := range data {
+			values[k] = v
+		}
+		body, err := p.RenderToString(file, values)
+		if err != nil {
+			return nil, err
+		}
+		*part.body = body
+	}
+	if !found {
+		return nil, &TemplateNotFoundError{Name: name, File: p.file(name + ".html")}
+	}
+	return email, nil
+}
+
+// Bytes return the message of the email in the format of RFC 5322, the
+// bodies are encoded as quoted-printable
+func (e *Email) Bytes() ([]byte, error) {
+	if e.Text == "" && e.HTML == "" {
+		return nil, errors.New("email without body")
+	}
+	var b bytes.Buffer
+	header := func(name, value string) {
+		b.WriteString(name + ": " + value + "\r\n")
+	}
+	if e.From != "" {
+		if err := checkAddresses(e.From); err != nil {
+			return nil, err
+		}
+		header("From", e.From)
+	}
+	for _, h := range []struct {
+		name string
+		list []string
+	}{{"To", e.To}, {"Cc", e.Cc}} {
+		if len(h.list) == 0 {
+			continue
+		}
+		if err := checkAddresses(h.list...); err != nil {
+			return nil, err
+		}
+		header(h.name, strings.Join(h.list, ", "))
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	names := make([]string, 0, len(e.Header))
+	for name := range e.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := e.Header[name]
+		if strings.ContainsAny(name+value, "\r\n") {
+			return nil, fmt.Errorf("invalid email header %s", name)
+		}
+		header(textproto.CanonicalMIMEHeaderKey(name), value)
+	}
+	header("MIME-Version", "1.0")
+
+	if e.Text == "" || e.HTML == "" {
+		body, contentType := e.Text, "text/plain; charset=utf-8"
+		if body == "" {
+			body, contentType = e.HTML, "text/html; charset=utf-8"
+		}
+		header("Content-Type", contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		b.WriteString("\r\n")
+		if err := writeQuotedPrintable(&b, body); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(&b)
+	header("Content-Type", `multipart/alternative; boundary="`+w.Boundary()+`"`)
+	b.WriteString("\r\n")
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{{"text/plain; charset=utf-8", e.Text}, {"text/html; charset=utf-8", e.HTML}} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func checkAddresses(addresses ...string) error {
+	for _, address := range addresses {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid email address %q: %w", address, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderEmail(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"mail/welcome.txt":  "Welcome <%= escape(name) %>!",
+		"mail/welcome.html": "<h1>Welcome <%= name %>!</h1>",
+		"mail/reset.txt":    "Reset <%= escape(name) %>",
+		"layout.html":       "<html><%= yield %></html>",
+	})
+	p := Default()
+	p.Options.TemplateDir = dir
+	p.Options.Layout = "layout.html"
+
+	email, err := p.RenderEmail("mail/welcome", map[string]interface{}{"name": "Tom & Ann"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Welcome Tom & Ann!", email.Text)
+	assert.Equal(t, "<h1>Welcome Tom &amp; Ann!</h1>", email.HTML, "the default layout is not used")
+
+	email.From = "Shop <noreply@example.com>"
+	email.To = []string{"tom@example.com", "Ann <ann@example.com>"}
+	email.Subject = "Bienvenue à vous"
+	email.Header = map[string]string{"reply-to": "help@example.com"}
+	buf, err := email.Bytes()
+	assert.Nil(t, err)
+	msg, err := mail.ReadMessage(bytes.NewReader(buf))
+	assert.Nil(t, err)
+	assert.Equal(t, "tom@example.com, Ann <ann@example.com>", msg.Header.Get("To"))
+	assert.Equal(t, "help@example.com", msg.Header.Get("Reply-To"))
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.Equal(t, "Bienvenue à vous", subject)
+	mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.Equal(t, "multipart/alternative", mediaType)
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for _, want := range [][2]string{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		part, err := r.NextRawPart()
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, want[0], part.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(quotedprintable.NewReader(part))
+		assert.Equal(t, want[1], string(body))
+	}
+
+	email, err = p.RenderEmail("mail/reset", map[string]interface{}{"name": "Tom"})
+	assert.Nil(t, err)
+	assert.Equal(t, "", email.HTML)
+	buf, _ = email.Bytes()
+	msg, _ = mail.ReadMessage(bytes.NewReader(buf))
+	assert.Equal(t, "text/plain; charset=utf-8", msg.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	assert.Equal(t, "Reset Tom", string(body))
+
+	_, err = p.RenderEmail("mail/missing", nil)
+	var notFound *TemplateNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+
+	for _, e := range []*Email{
+		{Text: ""},
+		{Text: "x", To: []string{"not an address"}},
+		{Text: "x", Header: map[string]string{"X-Injected": "a\r\nBcc: evil@example.com"}},
+	} {
+		_, err = e.Bytes()
+		assert.NotNil(t, err)
+	}
+}
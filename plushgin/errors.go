@@ -0,0 +1,94 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// TemplateNotFoundError is returned when the file of the template not exist,
+// Parent is the template including the missing partial
+type TemplateNotFoundError struct {
+	Name       string
+	File       string
+	Parent     string
+	ParentLine int
+}
+
+func (e *TemplateNotFoundError) Error() string {
+	return fmt.Sprintf("template %s not found: %s", e.Name, e.File) + parentString(e.Parent, e.ParentLine)
+}
+
+// TemplateName return the name of the missing template
+func (e *TemplateNotFoundError) TemplateName() string {
+	return e.Name
+}
+
+// TemplateExecError is returned when the template failed to render, Line and
+// Helper are parsed from the error of plush and empty if unknown. Parent is
+// the template including the failed partial.
+type TemplateExecError struct {
+	Name       string
+	File       string
+	Line       int
+	Helper     string
+	Parent     string
+	ParentLine int
+	Err        error
+}
+
+func (e *TemplateExecError) Error() string {
+	msg := e.File
+	if e.Line > 0 {
+		msg += ":" + strconv.Itoa(e.Line)
+	}
+	if e.Helper != "" {
+		msg += " in " + e.Helper
+	}
+	return msg + ": " + e.Err.Error() + parentString(e.Parent, e.ParentLine)
+}
+
+func parentString(parent string, line int) string {
+	if parent == "" {
+		return ""
+	}
+	if line > 0 {
+		parent += ":" + strconv.Itoa(line)
+	}
+	return " (included by " + parent + ")"
+}
+
+func (e *TemplateExecError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateName return the name of the failed template
+func (e *TemplateExecError) TemplateName() string {
+	return e.Name
+}
+
+var (
+	plushLineRegexp   = regexp.MustCompile(`^line (\d+): `)
+	plushHelperRegexp = regexp.MustCompile(`could not call (\w+) function|"(\w+)": unknown identifier`)
+)
+
+// execError wrap the error of plush, the errors of the nested templates are kept
+func (p *Plush2Render) execError(name string, err error) error {
+	switch err.(type) {
+	case *TemplateExecError, *TemplateNotFoundError:
+		return err
+	}
+	line := 0
+	if m := plushLineRegexp.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	e := &TemplateExecError{Name: name, File: p.file(name), Line: line, Err: err}
+	if m := plushHelperRegexp.FindStringSubmatch(err.Error()); m != nil {
+		e.Helper = m[1] + m[2]
+	}
+	return e
+}
@@ -6,20 +6,41 @@
 package plushgin
 
 func (p *Plush2Render) partial(n string) (string, error) {
-	buf, err := p.getCache("_" + n)
+	t, err := p.getTemplate("_" + n)
 	if err != nil {
 		return "", err
 	}
-	return string(buf), nil
+	return t.Input, nil
 }
 
 func (p *Plush2Render) initDefaultHelpers() {
 	p.AddHelper("partialFeeder", p.partial)
+	p.AddHelper("partial", p.partialHelper)
+	p.AddHelper("partialCollection", p.partialCollectionHelper)
+	p.AddHelper("escape", p.escapeHelper)
 }
 
+// AddHelper register a helper function usable in all the templates
 func (p *Plush2Render) AddHelper(fn string, f interface{}) {
 	if p.helpers == nil {
 		p.helpers = make(map[string]interface{})
 	}
 	p.helpers[fn] = f
 }
+
+// AddGlobal register a variable of all the templates, unless the data has
+// the same name
+func (p *Plush2Render) AddGlobal(name string, value interface{}) {
+	if p.globals == nil {
+		p.globals = make(map[string]interface{})
+	}
+	p.globals[name] = value
+}
+
+// AddContextFunc register f to add the variables of the templates rendered
+// in a request, such as the current user. The names of the data are not
+// overridden. The gin context is only known with the UseContext middleware,
+// f is not called by RenderToString.
+func (p *Plush2Render) AddContextFunc(f ContextFunc) {
+	p.contextFuncs = append(p.contextFuncs, f)
+}
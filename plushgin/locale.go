@@ -0,0 +1,57 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+// LocaleKey is the name of the locale in the data of the templates, the
+// variants of the locale such as index.fr.html are rendered instead of
+// index.html if exist
+const LocaleKey = "locale"
+
+// localized return the variant of the template for the locale of ctx, for
+// fr-CA index.fr-CA.html then index.fr.html are tried
+func (p *Plush2Render) localized(ctx context.Context, name string) string {
+	locale, _ := ctx.Value(LocaleKey).(string)
+	if !validLocale(locale) {
+		return name
+	}
+	for {
+		if variant := localeVariant(name, locale); p.exists(variant) {
+			return variant
+		}
+		i := strings.LastIndex(locale, "-")
+		if i <= 0 {
+			return name
+		}
+		locale = locale[:i]
+	}
+}
+
+// localeVariant insert the locale after the first part of the base name,
+// such as users/_row.fr.html and report.fr.json.plush
+func localeVariant(name, locale string) string {
+	dir, base := path.Split(name)
+	if i := strings.Index(base, "."); i > 0 {
+		return dir + base[:i] + "." + locale + base[i:]
+	}
+	return name + "." + locale
+}
+
+func validLocale(locale string) bool {
+	if locale == "" {
+		return false
+	}
+	for _, r := range locale {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocaleVariant(t *testing.T) {
+	assert.Equal(t, "index.fr.html", localeVariant("index.html", "fr"))
+	assert.Equal(t, "users/_row.pt-BR.html", localeVariant("users/_row.html", "pt-BR"))
+	assert.Equal(t, "report.fr.json.plush", localeVariant("report.json.plush", "fr"))
+	assert.Equal(t, "shared:mail.fr", localeVariant("shared:mail", "fr"))
+}
+
+func TestLocalizedTemplate(t *testing.T) {
+	defer gin.SetMode(gin.Mode())
+	dir := writeTemplates(t, map[string]string{
+		"index.html":    "default",
+		"index.fr.html": "fr",
+	})
+	p := Default()
+	p.Options.TemplateDir = dir
+
+	gin.SetMode(gin.ReleaseMode)
+	for locale, want := range map[string]string{
+		"":      "default",
+		"fr":    "fr",
+		"fr-CA": "fr",
+		"de":    "default",
+		"../fr": "default",
+	} {
+		out, err := renderString(p, "index.html", gin.H{LocaleKey: locale})
+		assert.Nil(t, err)
+		assert.Equal(t, want, out, locale)
+	}
+	assert.True(t, p.cache.IsMissing("index.de.html"))
+
+	// the missing variant is only found again in debug mode
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "index.de.html"), []byte("de"), 0600))
+	out, _ := renderString(p, "index.html", gin.H{LocaleKey: "de"})
+	assert.Equal(t, "default", out)
+	gin.SetMode(gin.DebugMode)
+	out, _ = renderString(p, "index.html", gin.H{LocaleKey: "de"})
+	assert.Equal(t, "de", out)
+}
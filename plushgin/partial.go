@@ -0,0 +1,185 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gobuffalo/plush"
+)
+
+const (
+	// templateKey is the name of the template being rendered in the context
+	templateKey = "_template_"
+	// partialErrorKey is the *partialErrors of the context
+	partialErrorKey = "_partial_error_"
+)
+
+// partialErrors keep the error of the failed partial, since plush only keep
+// the message of the errors returned by the helpers
+type partialErrors struct {
+	err error
+}
+
+// partialFile return the file of the partial, name is used as is if exists,
+// otherwise its base name is prefixed by _ such as users/_row.html for
+// users/row.html
+func (p *Plush2Render) partialFile(name string) (string, error) {
+	_, _, err := p.lookup(name)
+	ns, file := splitNamespace(name)
+	dir, base := path.Split(file)
+	if err == nil || strings.HasPrefix(base, "_") {
+		return name, err
+	}
+	prefixed := dir + "_" + base
+	if ns != "" {
+		prefixed = ns + ":" + prefixed
+	}
+	if _, _, err2 := p.lookup(prefixed); err2 == nil {
+		return prefixed, nil
+	}
+	return name, err
+}
+
+// renderPartial render the partial in a new context of help with data, the
+// parsed partial is cached as the templates
+func (p *Plush2Render) renderPartial(name string, data map[string]interface{}, help plush.HelperContext) (template.HTML, error) {
+	name, err := p.partialFile(name)
+	if err != nil {
+		return "", err
+	}
+	name = p.localized(help, name)
+	t, err := p.getTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	ctx := help.New()
+	// the new context has the default helpers of plush such as partial
+	for fn, f := range p.helpers {
+		ctx.Set(fn, f)
+	}
+	for k, v := range data {
+		ctx.Set(k, v)
+	}
+	ctx.Set(templateKey, name)
+	rendered, err := t.Exec(ctx)
+	if err != nil {
+		return "", p.renderError(ctx, name, err)
+	}
+	if layout, ok := data["layout"].(string); ok && layout != "" {
+		return p.renderPartial(layout, map[string]interface{}{"yield": template.HTML(rendered)}, help)
+	}
+	return template.HTML(rendered), nil
+}
+
+// partialHelper render the partial with the data merged to the current
+// context, the errors name the including template and the partial
+//
+//	<%= partial("users/_row.html", {user: user}) %>
+func (p *Plush2Render) partialHelper(name string, data map[string]interface{}, help plush.HelperContext) (template.HTML, error) {
+	rendered, err := p.renderPartial(name, data, help)
+	if err != nil {
+		return "", p.partialError(name, help, err)
+	}
+	return rendered, nil
+}
+
+// partialCollectionHelper render the partial for every item of a slice or an
+// array. The item is named by the "as" of data, or the partial name without
+// _ and extension, and "index" is its index.
+//
+//	<%= partialCollection("users/_row.html", users, {as: "user"}) %>
+func (p *Plush2Render) partialCollectionHelper(name string, collection interface{}, data map[string]interface{}, help plush.HelperContext) (template.HTML, error) {
+	if collection == nil {
+		return "", nil
+	}
+	items := reflect.ValueOf(collection)
+	if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+		return "", p.partialError(name, help, fmt.Errorf("%T is not a collection", collection))
+	}
+	as, _ := data["as"].(string)
+	if as == "" {
+		as = partialLocal(name)
+	}
+	var b strings.Builder
+	locals := make(map[string]interface{}, len(data)+2)
+	for k, v := range data {
+		locals[k] = v
+	}
+	delete(locals, "as")
+	for i := 0; i < items.Len(); i++ {
+		locals[as] = items.Index(i).Interface()
+		locals["index"] = i
+		rendered, err := p.renderPartial(name, locals, help)
+		if err != nil {
+			return "", p.partialError(name, help, err)
+		}
+		b.WriteString(string(rendered))
+	}
+	return template.HTML(b.String()), nil
+}
+
+// partialLocal return the name of the item of a partial collection, row for
+// users/_row.html
+func partialLocal(name string) string {
+	_, file := splitNamespace(name)
+	base := strings.TrimPrefix(path.Base(file), "_")
+	if i := strings.Index(base, "."); i > 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// partialError name the including template of the error of a partial, the
+// error is kept in the context for renderError
+func (p *Plush2Render) partialError(name string, help plush.HelperContext, err error) error {
+	parent, _ := help.Value(templateKey).(string)
+	switch e := err.(type) {
+	case *TemplateExecError:
+		if e.Parent == "" {
+			e.Parent = parent
+		}
+	case *TemplateNotFoundError:
+		if e.Parent == "" {
+			e.Parent = parent
+		}
+	default:
+		err = &TemplateExecError{Name: name, File: p.file(name), Parent: parent, Err: err}
+	}
+	if holder, ok := help.Value(partialErrorKey).(*partialErrors); ok && holder.err == nil {
+		holder.err = err
+	}
+	return err
+}
+
+// renderError return the error of the failed partial kept in ctx with the
+// line of its call, or the error of plush wrapped by execError
+func (p *Plush2Render) renderError(ctx context.Context, name string, err error) error {
+	holder, _ := ctx.Value(partialErrorKey).(*partialErrors)
+	if holder == nil || holder.err == nil {
+		return p.execError(name, err)
+	}
+	line := 0
+	if m := plushLineRegexp.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	switch e := holder.err.(type) {
+	case *TemplateExecError:
+		if e.Parent == name && e.ParentLine == 0 {
+			e.ParentLine = line
+		}
+	case *TemplateNotFoundError:
+		if e.Parent == name && e.ParentLine == 0 {
+			e.ParentLine = line
+		}
+	}
+	return holder.err
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type partialUser struct {
+	Name string
+}
+
+func TestPartial(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"users/index.html":    `<%= partial("users/_row.html", {user: users[0]}) %>|<%= partial("users/row.html", {user: users[1]}) %>|<%= title %>`,
+		"users/_row.html":     `<%= title %>:<%= user.Name %>`,
+		"users/list.html":     `<ul><%= partialCollection("users/_user.html", users) %></ul>`,
+		"users/_user.html":    `<li><%= user.Name %></li>`,
+		"users/as.html":       `<%= partialCollection("users/_item.html", users, {as: "u", sep: ","}) %>`,
+		"users/_item.html":    `<%= index %>=<%= u.Name %><%= sep %>`,
+		"users/nested.html":   `<%= partial("users/_outer.html") %>`,
+		"users/_outer.html":   `[<%= partial("users/_row.html", {user: users[0]}) %>]`,
+		"users/layout.html":   `<%= partial("users/_row.html", {user: users[0], layout: "users/_box.html"}) %>`,
+		"users/_box.html":     `<box><%= yield %></box>`,
+		"users/escape.html":   `<%= partial("users/_row.html", {user: evil}) %>`,
+		"_legacy":             `legacy <%= title %>`,
+		"legacy.html":         `<%= partial("legacy") %>`,
+		"broken/index.html":   "ok\n<%= partial(\"broken/_row.html\") %>",
+		"broken/_row.html":    "row\n\n<%= boom() %>",
+		"broken/missing.html": "\n<%= partial(\"broken/_none.html\") %>",
+	})
+	p := Default()
+	p.Options.TemplateDir = dir
+	data := gin.H{
+		"title": "T",
+		"users": []partialUser{{"tom"}, {"ann"}},
+		"evil":  partialUser{"<b>"},
+	}
+	for name, want := range map[string]string{
+		"users/index.html":  "T:tom|T:ann|T",
+		"users/list.html":   "<ul><li>tom</li><li>ann</li></ul>",
+		"users/as.html":     "0=tom,1=ann,",
+		"users/nested.html": "[T:tom]",
+		"users/layout.html": "<box>T:tom</box>",
+		"users/escape.html": "T:&lt;b&gt;",
+		"legacy.html":       "legacy T",
+	} {
+		out, err := renderString(p, name, data)
+		assert.Nil(t, err, name)
+		assert.Equal(t, want, out, name)
+	}
+	assert.NotNil(t, p.cache.Get("users/_row.html"), "the parsed partial is cached")
+
+	_, err := renderString(p, "broken/index.html", nil)
+	var execErr *TemplateExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "broken/_row.html", execErr.Name)
+		assert.Equal(t, 3, execErr.Line)
+		assert.Equal(t, "broken/index.html", execErr.Parent)
+		assert.Equal(t, 2, execErr.ParentLine)
+		assert.Contains(t, err.Error(), filepath.Join(dir, "broken/_row.html")+":3")
+		assert.Contains(t, err.Error(), "(included by broken/index.html:2)")
+	}
+
+	_, err = renderString(p, "broken/missing.html", nil)
+	var notFound *TemplateNotFoundError
+	if assert.True(t, errors.As(err, &notFound)) {
+		assert.Equal(t, "broken/_none.html", notFound.Name)
+		assert.Equal(t, "broken/missing.html", notFound.Parent)
+		assert.Equal(t, 2, notFound.ParentLine)
+	}
+
+	p.AddHelper("boom", func() (string, error) { return "", errors.New("kaboom") })
+	_, err = renderString(p, "users/list.html", gin.H{"users": "tom"})
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "users/_user.html", execErr.Name)
+		assert.Equal(t, "users/list.html", execErr.Parent)
+		assert.Contains(t, err.Error(), "string is not a collection")
+	}
+}
@@ -6,9 +6,13 @@
 package plushgin
 
 import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
 	"net/http"
 	"os"
-	"path"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/render"
@@ -21,11 +25,35 @@ const htmlContentType = "text/html; charset=utf-8"
 
 // RenderOptions is used to configure the renderer.
 type RenderOptions struct {
-	TemplateDir     string
-	ContentType     string
+	// TemplateDir is the directory of the templates if FS is nil, or the
+	// overlay of FS
+	TemplateDir string
+	// FS is the file system of the templates such as an embed.FS, the names
+	// of the templates are relative to its root
+	FS fs.FS
+	// Overlay let the files in TemplateDir override the files of FS, used
+	// to edit the embedded templates during development
+	Overlay bool
+	// Roots is the ordered list of the template roots looked up before
+	// TemplateDir and FS, the first root having the file serve it. The
+	// templates of a named root can be referenced as "name:file".
+	Roots []TemplateRoot
+	// ContentType is the content type of the .html templates and the
+	// templates of unknown extension
+	ContentType string
+	// ContentTypes are the content types of the extensions such as ".vcf",
+	// which override DefaultContentTypes
+	ContentTypes    map[string]string
 	MaxCacheEntries int
+	// Layout is the default layout of the templates, its yield is the
+	// rendered template. It is overridden by the layout of the data, an
+	// empty string or false of the data render without layout.
+	Layout string
 }
 
+// maxLayoutDepth limit the nested layouts, so a loop of layouts is an error
+const maxLayoutDepth = 16
+
 // Plush2Render is a custom Gin template renderer using plush.
 type Plush2Render struct {
 	Options *RenderOptions
@@ -33,6 +61,11 @@ type Plush2Render struct {
 	Context plush.Context
 	cache   *templateCache
 	helpers map[string]interface{}
+	globals map[string]interface{}
+	named   map[string][]string
+	data    gin.H
+
+	contextFuncs []ContextFunc
 }
 
 // New creates a new Plush2Render instance with custom Options.
@@ -59,51 +92,223 @@ func Default() *Plush2Render {
 // the template by either loading it from disk or using plush's cache.
 func (p *Plush2Render) Instance(name string, data interface{}) render.Render {
 	log.Logger.Level(zerolog.DebugLevel)
+	h, _ := data.(gin.H)
 	return &Plush2Render{
-		Context: NewContext(p, data.(gin.H)),
-		Options: p.Options,
-		cache:   p.cache,
-		Name:    name,
+		Context:      NewContext(p, h),
+		Options:      p.Options,
+		cache:        p.cache,
+		named:        p.named,
+		data:         h,
+		contextFuncs: p.contextFuncs,
+		Name:         name,
 	}
 }
 
-// Render should render the template to the response.
-func (p *Plush2Render) Render(w http.ResponseWriter) error {
-	var err error
-	var renderedStr string
+// AddTemplate register a template of multiple files, the last file is the
+// template and the others are its layouts from the outermost
+func (p *Plush2Render) AddTemplate(name string, files ...string) {
+	if len(files) == 0 {
+		return
+	}
+	if p.named == nil {
+		p.named = make(map[string][]string)
+	}
+	p.named[name] = files
+}
 
-	buf, err := p.getCache(p.Name)
-	if err != nil {
-		panic(err)
+// Render should render the template to the response. The template is
+// rendered to a buffer first, nothing is written if it failed and the
+// TemplateNotFoundError or TemplateExecError is returned. The variables of
+// the context functions are set if the writer is wrapped by UseContext.
+func (p *Plush2Render) Render(w http.ResponseWriter) error {
+	if c := ginContext(w); c != nil {
+		p.setContext(c)
 	}
-	renderedStr, err = plush.Render(string(buf), &p.Context)
+	rendered, err := p.render()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	rendered := []byte(renderedStr)
 	p.WriteContentType(w)
-	_, err = w.Write(rendered)
+	_, err = w.Write([]byte(rendered))
 	return err
 }
 
-func (p *Plush2Render) getCache(name string) ([]byte, error) {
-	buf := p.cache.Get(name)
-	if buf == nil || gin.Mode() == "debug" {
-		filename := path.Join(p.Options.TemplateDir, name)
-		var err error
-		buf, err = os.ReadFile(filename)
+// render render the template in its layouts
+func (p *Plush2Render) render() (string, error) {
+	layouts := p.layouts()
+	name := p.Name
+	rendered, next, err := p.execute(name)
+	if err != nil {
+		return "", err
+	}
+	if next != "" {
+		layouts = []string{next}
+	}
+	for depth := 0; len(layouts) > 0; depth++ {
+		if depth >= maxLayoutDepth {
+			return "", p.execError(name, fmt.Errorf("more than %d nested layouts", maxLayoutDepth))
+		}
+		name, layouts = layouts[len(layouts)-1], layouts[:len(layouts)-1]
+		p.Context.Set("yield", template.HTML(rendered))
+		rendered, next, err = p.execute(name)
+		if err != nil {
+			return "", err
+		}
+		if next != "" {
+			layouts = append(layouts, next)
+		}
+	}
+	return rendered, nil
+}
+
+// RenderToString render the template with data outside of a request, such
+// as the emails and the reports. The escape helper writes the values for the
+// content type of the template, such as JSON strings for .json and as is for .txt.
+func (p *Plush2Render) RenderToString(name string, data map[string]interface{}) (string, error) {
+	return p.Instance(name, gin.H(data)).(*Plush2Render).render()
+}
+
+// layouts return the layouts of the template from the outermost
+func (p *Plush2Render) layouts() []string {
+	if files, ok := p.named[p.Name]; ok {
+		p.Name = files[len(files)-1]
+		return append([]string(nil), files[:len(files)-1]...)
+	}
+	// the default layout is only used by the templates of the default
+	// content type, such as .html but not .txt
+	layout := ""
+	if p.contentType(p.Name) == p.Options.ContentType {
+		layout = p.Options.Layout
+	}
+	if p.Context.Has("layout") {
+		layout, _ = p.Context.Value("layout").(string)
+	}
+	if layout == "" {
+		return nil
+	}
+	return []string{layout}
+}
+
+// execute render one file, next is the layout set by useLayout in it
+func (p *Plush2Render) execute(name string) (rendered string, next string, err error) {
+	name = p.localized(&p.Context, name)
+	p.Context.Set(templateKey, name)
+	p.Context.Set(partialErrorKey, &partialErrors{})
+	p.Context.Set("useLayout", func(layout string) string {
+		next = layout
+		return ""
+	})
+	t, err := p.getTemplate(name)
+	if err != nil {
+		return "", "", err
+	}
+	rendered, err = t.Exec(&p.Context)
+	if err != nil {
+		return "", "", p.renderError(&p.Context, name, err)
+	}
+	return rendered, next, nil
+}
+
+// getTemplate return the parsed template, in debug mode the template is
+// parsed again if its file is modified
+func (p *Plush2Render) getTemplate(name string) (*plush.Template, error) {
+	cached := p.cache.Get(name)
+	if cached != nil && gin.Mode() != gin.DebugMode {
+		return cached.template, nil
+	}
+	root, file, err := p.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := root.stat(file)
+	if err != nil {
+		return nil, err
+	}
+	filename := root.file(file)
+	if cached != nil && cached.file == filename && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.template, nil
+	}
+	buf, err := root.readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	t, err := plush.NewTemplate(string(buf))
+	if err != nil {
+		return nil, p.execError(name, err)
+	}
+	log.Debug().Str("template", name).Str("root", root.String()).Str("file", filename).Msg("template loaded")
+	p.cache.Add(name, &cachedTemplate{template: t, file: filename, modTime: info.ModTime(), size: info.Size()})
+	return t, nil
+}
+
+// Precompile parse all the files of the templates, so the syntax errors are
+// found before serving. The hidden files and the files hidden by a previous
+// root are skipped, the files of a named root are parsed with its namespace.
+func (p *Plush2Render) Precompile() error {
+	var names []string
+	seen := map[string]bool{}
+	for _, root := range p.Options.roots() {
+		if root.FS == nil {
+			if info, err := os.Stat(root.file(".")); err != nil || !info.IsDir() {
+				continue
+			}
+		}
+		err := fs.WalkDir(root.fsys(), ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(d.Name(), ".") && name != "." {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			if root.Name != "" {
+				names = append(names, root.Name+":"+name)
+			}
+			return nil
+		})
 		if err != nil {
-			return nil, err
+			return err
+		}
+	}
+	for _, name := range names {
+		if _, err := p.getTemplate(name); err != nil {
+			return err
 		}
-		p.cache.Add(name, buf)
 	}
-	return buf, nil
+	return nil
 }
 
-// WriteContentType should add the Content-Type header to the response when not set yet.
+// file return the file of the template, the name itself if it is in FS
+func (p *Plush2Render) file(name string) string {
+	root, file, err := p.lookup(name)
+	if err != nil {
+		var notFound *TemplateNotFoundError
+		if errors.As(err, &notFound) {
+			return notFound.File
+		}
+		return name
+	}
+	return root.file(file)
+}
+
+// WriteContentType should add the Content-Type header to the response when not set yet,
+// the content type is inferred from the extension of the template.
 func (p *Plush2Render) WriteContentType(w http.ResponseWriter) {
 	header := w.Header()
 	if val := header["Content-Type"]; len(val) == 0 {
-		header["Content-Type"] = []string{p.Options.ContentType}
+		name := p.Name
+		if files, ok := p.named[name]; ok {
+			name = files[len(files)-1]
+		}
+		header["Content-Type"] = []string{p.contentType(name)}
 	}
 }
@@ -0,0 +1,136 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gobuffalo/plush"
+	"github.com/stretchr/testify/assert"
+)
+
+type benchItem struct {
+	Name string
+	Done bool
+}
+
+func benchData() gin.H {
+	items := make([]benchItem, 20)
+	for i := range items {
+		items[i] = benchItem{Name: fmt.Sprintf("item %d", i), Done: i%2 == 0}
+	}
+	return gin.H{"title": "Benchmark", "items": items}
+}
+
+func renderString(p *Plush2Render, name string, data gin.H) (string, error) {
+	w := httptest.NewRecorder()
+	err := p.Instance(name, data).Render(w)
+	return w.Body.String(), err
+}
+
+func TestTemplateModified(t *testing.T) {
+	defer gin.SetMode(gin.Mode())
+	dir := t.TempDir()
+	file := filepath.Join(dir, "index.html")
+	p := Default()
+	p.Options.TemplateDir = dir
+	assert.Nil(t, os.WriteFile(file, []byte("v1 <%= name %>"), 0600))
+
+	gin.SetMode(gin.ReleaseMode)
+	out, err := renderString(p, "index.html", gin.H{"name": "tom"})
+	assert.Nil(t, err)
+	assert.Equal(t, "v1 tom", out)
+
+	assert.Nil(t, os.WriteFile(file, []byte("v2 <%= name %>"), 0600))
+	assert.Nil(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)))
+	out, _ = renderString(p, "index.html", gin.H{"name": "tom"})
+	assert.Equal(t, "v1 tom", out, "release mode keep the parsed template")
+
+	gin.SetMode(gin.DebugMode)
+	out, _ = renderString(p, "index.html", gin.H{"name": "tom"})
+	assert.Equal(t, "v2 tom", out, "debug mode parse the modified file")
+}
+
+func TestPrecompile(t *testing.T) {
+	dir := t.TempDir()
+	p := Default()
+	p.Options.TemplateDir = dir
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "admin"), 0700))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, ".git"), 0700))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<%= name %>"), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, ".git", "broken"), []byte("<%= if ( %>"), 0600))
+	assert.Nil(t, p.Precompile())
+	assert.NotNil(t, p.cache.Get("index.html"))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "admin", "broken.html"), []byte("ok\n<%= if ( %>"), 0600))
+	err := p.Precompile()
+	var execErr *TemplateExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "admin/broken.html", execErr.Name)
+		assert.Equal(t, 2, execErr.Line)
+	}
+
+	p.Options.TemplateDir = filepath.Join(dir, "missing")
+	assert.Nil(t, p.Precompile())
+}
+
+// BenchmarkRenderParsed render with the parsed template cached
+func BenchmarkRenderParsed(b *testing.B) {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.ReleaseMode)
+	p := Default()
+	p.Options.TemplateDir = "testdata"
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := renderString(p, "bench.html", data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRenderDebug check the mtime of the file for every render
+func BenchmarkRenderDebug(b *testing.B) {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.DebugMode)
+	p := Default()
+	p.Options.TemplateDir = "testdata"
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := renderString(p, "bench.html", data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRenderReparse read and parse the file for every render, as it
+// was done before the parsed templates are cached
+func BenchmarkRenderReparse(b *testing.B) {
+	p := Default()
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, err := os.ReadFile("testdata/bench.html")
+		if err != nil {
+			b.Fatal(err)
+		}
+		t, err := plush.NewTemplate(string(buf))
+		if err != nil {
+			b.Fatal(err)
+		}
+		ctx := NewContext(p, data)
+		if _, err := t.Exec(&ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TemplateRoot is a directory or a file system of templates
+type TemplateRoot struct {
+	// Name is the namespace of the root, its templates can be referenced as
+	// "name:file" besides the first match of all the roots
+	Name string
+	// Dir is the directory of the templates, used if FS is nil
+	Dir string
+	// FS is the file system of the templates such as an embed.FS
+	FS fs.FS
+}
+
+// String return the directory of the root for the logs
+func (r *TemplateRoot) String() string {
+	s := r.Dir
+	if r.FS != nil {
+		s = "fs"
+	}
+	if r.Name != "" {
+		s = r.Name + ":" + s
+	}
+	return s
+}
+
+func (r *TemplateRoot) stat(name string) (fs.FileInfo, error) {
+	if r.FS != nil {
+		return fs.Stat(r.FS, path.Clean(name))
+	}
+	return os.Stat(path.Join(r.Dir, name))
+}
+
+func (r *TemplateRoot) readFile(name string) ([]byte, error) {
+	if r.FS != nil {
+		return fs.ReadFile(r.FS, path.Clean(name))
+	}
+	return os.ReadFile(path.Join(r.Dir, name))
+}
+
+// file return the file of the template, the name itself if it is in FS
+func (r *TemplateRoot) file(name string) string {
+	if r.FS != nil {
+		return path.Clean(name)
+	}
+	return path.Join(r.Dir, name)
+}
+
+func (r *TemplateRoot) fsys() fs.FS {
+	if r.FS != nil {
+		return r.FS
+	}
+	if r.Dir == "" {
+		return os.DirFS(".")
+	}
+	return os.DirFS(r.Dir)
+}
+
+// roots return the roots in the order of the lookup, Roots first, then
+// TemplateDir and FS
+func (o *RenderOptions) roots() []TemplateRoot {
+	roots := append([]TemplateRoot(nil), o.Roots...)
+	if o.FS == nil && (o.TemplateDir != "" || len(o.Roots) == 0) || o.FS != nil && o.Overlay {
+		roots = append(roots, TemplateRoot{Dir: o.TemplateDir})
+	}
+	if o.FS != nil {
+		roots = append(roots, TemplateRoot{FS: o.FS})
+	}
+	return roots
+}
+
+// splitNamespace split "name:file" to the namespace and the file
+func splitNamespace(name string) (string, string) {
+	if i := strings.Index(name, ":"); i > 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// lookup return the first root having the template and the name of the
+// file in it. The lookup is cached unless in debug mode.
+func (p *Plush2Render) lookup(name string) (*TemplateRoot, string, error) {
+	ns, file := splitNamespace(name)
+	debug := gin.Mode() == gin.DebugMode
+	if !debug {
+		if root := p.cache.Lookup(name); root != nil {
+			return root, file, nil
+		}
+	}
+	roots := p.Options.roots()
+	var first *TemplateRoot
+	for i := range roots {
+		root := &roots[i]
+		if ns != "" && root.Name != ns {
+			continue
+		}
+		if first == nil {
+			first = root
+		}
+		_, err := root.stat(file)
+		if err == nil {
+			if !debug {
+				p.cache.AddLookup(name, root)
+			}
+			return root, file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrInvalid) {
+			return nil, "", err
+		}
+	}
+	notFound := &TemplateNotFoundError{Name: name, File: file}
+	if first != nil {
+		notFound.File = first.file(file)
+	}
+	return nil, "", notFound
+}
+
+// exists return true if the template exists, the missing templates are
+// cached unless in debug mode
+func (p *Plush2Render) exists(name string) bool {
+	debug := gin.Mode() == gin.DebugMode
+	if !debug && p.cache.IsMissing(name) {
+		return false
+	}
+	_, _, err := p.lookup(name)
+	if err != nil && !debug {
+		p.cache.AddMissing(name)
+	}
+	return err == nil
+}
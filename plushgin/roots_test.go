@@ -0,0 +1,112 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package plushgin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(file), 0700))
+		assert.Nil(t, os.WriteFile(file, []byte(content), 0600))
+	}
+	return dir
+}
+
+func TestTemplateRoots(t *testing.T) {
+	tenant := writeTemplates(t, map[string]string{
+		"index.html": "tenant <%= name %>",
+	})
+	app := writeTemplates(t, map[string]string{
+		"index.html": "app <%= name %>",
+		"about.html": "about",
+	})
+	shared := writeTemplates(t, map[string]string{
+		"index.html":        "shared index",
+		"email/footer.html": "footer of <%= name %>",
+	})
+	p := Default()
+	p.Options.TemplateDir = ""
+	p.Options.FS = fstest.MapFS{"embedded.html": {Data: []byte("embedded")}}
+	p.Options.Roots = []TemplateRoot{{Dir: tenant}, {Dir: app}, {Name: "shared", Dir: shared}}
+
+	for name, want := range map[string]string{
+		"index.html":               "tenant tom",
+		"about.html":               "about",
+		"email/footer.html":        "footer of tom",
+		"shared:index.html":        "shared index",
+		"shared:email/footer.html": "footer of tom",
+		"embedded.html":            "embedded",
+	} {
+		out, err := renderString(p, name, gin.H{"name": "tom"})
+		assert.Nil(t, err, name)
+		assert.Equal(t, want, out, name)
+	}
+
+	_, err := renderString(p, "shared:about.html", nil)
+	var notFound *TemplateNotFoundError
+	if assert.True(t, errors.As(err, &notFound)) {
+		assert.Equal(t, "shared:about.html", notFound.Name)
+		assert.Equal(t, filepath.Join(shared, "about.html"), notFound.File)
+	}
+	_, err = renderString(p, "other:index.html", nil)
+	assert.True(t, errors.As(err, &notFound))
+}
+
+func TestTemplateRootsLookupCache(t *testing.T) {
+	defer gin.SetMode(gin.Mode())
+	tenant := writeTemplates(t, map[string]string{})
+	app := writeTemplates(t, map[string]string{"index.html": "app"})
+	p := Default()
+	p.Options.Roots = []TemplateRoot{{Dir: tenant}, {Dir: app}}
+	p.Options.TemplateDir = ""
+
+	gin.SetMode(gin.ReleaseMode)
+	out, _ := renderString(p, "index.html", nil)
+	assert.Equal(t, "app", out)
+	root := p.cache.Lookup("index.html")
+	if assert.NotNil(t, root) {
+		assert.Equal(t, app, root.Dir)
+	}
+
+	// a new file of a previous root is only found in debug mode
+	assert.Nil(t, os.WriteFile(filepath.Join(tenant, "index.html"), []byte("tenant"), 0600))
+	p.cache.cache.Purge()
+	out, _ = renderString(p, "index.html", nil)
+	assert.Equal(t, "app", out)
+
+	gin.SetMode(gin.DebugMode)
+	out, _ = renderString(p, "index.html", nil)
+	assert.Equal(t, "tenant", out)
+}
+
+func TestPrecompileRoots(t *testing.T) {
+	app := writeTemplates(t, map[string]string{"index.html": "app"})
+	shared := writeTemplates(t, map[string]string{"index.html": "<%= if ( %>"})
+	p := Default()
+	p.Options.TemplateDir = ""
+	p.Options.Roots = []TemplateRoot{{Dir: app}, {Name: "shared", Dir: shared}}
+
+	err := p.Precompile()
+	var execErr *TemplateExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "shared:index.html", execErr.Name)
+		assert.Equal(t, filepath.Join(shared, "index.html"), execErr.File)
+	}
+
+	p.Options.Roots[1].Name = ""
+	p.cache = newTemplateCache(p.Options.MaxCacheEntries)
+	assert.Nil(t, p.Precompile(), "the file hidden by the first root is skipped")
+}
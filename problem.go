@@ -0,0 +1,160 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorFormat is the format of the error response
+type ErrorFormat int
+
+const (
+	// ErrorFormatAuto choose the format according to the Accept header
+	ErrorFormatAuto ErrorFormat = iota
+	// ErrorFormatHTML render the error template configured in gin.conf
+	ErrorFormatHTML
+	// ErrorFormatJSON write application/problem+json (RFC 7807)
+	ErrorFormatJSON
+	// ErrorFormatText write plain text
+	ErrorFormatText
+)
+
+const (
+	problemContentType = "application/problem+json"
+	errorFormatKey     = "_error_format_"
+	configKey          = "_gin_config_"
+)
+
+// Problem is the RFC 7807 error response send to API clients
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ForceErrorFormat is a middleware to force the error format of a route group,
+// for example api.Use(ForceErrorFormat(ErrorFormatJSON))
+func ForceErrorFormat(format ErrorFormat) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(errorFormatKey, format)
+		c.Next()
+	}
+}
+
+// ErrorResponse abort the request and write the error response of status.
+// API clients get application/problem+json, browsers get the error template
+// configured in gin.conf, and others get plain text.
+// The detail of err is only exposed for status < 500 or in debug mode.
+func ErrorResponse(c *gin.Context, status int, err error) {
+	c.Abort()
+	if c.Writer.Written() {
+		return
+	}
+	var config *Config
+	if v, ok := c.Get(configKey); ok {
+		config, _ = v.(*Config)
+	}
+	detail := ""
+	if err != nil && (status < http.StatusInternalServerError || gin.IsDebugging()) {
+		detail = err.Error()
+	}
+	format := negotiateError(c, config, status)
+	if format == ErrorFormatHTML {
+		name, _ := config.errorTemplate(status)
+		c.HTML(status, name, gin.H{
+			"errors":     config.errors,
+			"status":     status,
+			"title":      http.StatusText(status),
+			"detail":     detail,
+			"request_id": RequestID(c),
+			// the flash messages are kept for the next page
+			"flashes": []FlashMessage(nil),
+		})
+		if c.Writer.Written() {
+			return
+		}
+		// the error template is broken, never render it again
+		format = ErrorFormatText
+	}
+	switch format {
+	case ErrorFormatJSON:
+		c.Header("Content-Type", problemContentType)
+		c.JSON(status, &Problem{
+			Type:      "about:blank",
+			Title:     http.StatusText(status),
+			Status:    status,
+			Detail:    detail,
+			Instance:  c.Request.URL.Path,
+			RequestID: RequestID(c),
+		})
+	default:
+		text := fmt.Sprintf("%d %s", status, http.StatusText(status))
+		if detail != "" {
+			text += ": " + detail
+		}
+		c.String(status, text)
+	}
+}
+
+func negotiateError(c *gin.Context, config *Config, status int) ErrorFormat {
+	_, hasTemplate := config.errorTemplate(status)
+	format := requestedErrorFormat(c)
+	if format == ErrorFormatHTML && !hasTemplate {
+		format = ErrorFormatText
+	}
+	return format
+}
+
+// requestedErrorFormat return the format forced by the route group or accepted
+// by the client. HTML is chosen only if the client names it, so the clients
+// without Accept or with */* get the plain text.
+func requestedErrorFormat(c *gin.Context) ErrorFormat {
+	format := ErrorFormatAuto
+	if v, ok := c.Get(errorFormatKey); ok {
+		format, _ = v.(ErrorFormat)
+	}
+	if format == ErrorFormatAuto {
+		offers := []string{gin.MIMEPlain, problemContentType, gin.MIMEJSON}
+		if acceptHTML(c) {
+			offers = append([]string{gin.MIMEHTML}, offers...)
+		}
+		switch c.NegotiateFormat(offers...) {
+		case gin.MIMEHTML:
+			format = ErrorFormatHTML
+		case problemContentType, gin.MIMEJSON:
+			format = ErrorFormatJSON
+		default:
+			format = ErrorFormatText
+		}
+	}
+	return format
+}
+
+// acceptHTML return true if the Accept header names text/html
+func acceptHTML(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), gin.MIMEHTML)
+}
+
+// IsBrowser return true if the request is from a browser, which accept HTML
+// and the error format is not forced to another one
+func IsBrowser(c *gin.Context) bool {
+	return acceptHTML(c) && requestedErrorFormat(c) == ErrorFormatHTML
+}
+
+func (c *Config) errorTemplate(status int) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	name, ok := c.errors[status]
+	return name, ok
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func TestErrorResponse(t *testing.T) {
+	config := initConfig()
+	config.errors[404] = "error/404.html"
+	config.errors[500] = "error/500.html"
+	engine := gin.New()
+	render := plushgin.Default()
+	render.Options.TemplateDir = "testdata/templates"
+	engine.HTMLRender = render
+	engine.Use(UseRequestID(), ginRecovery(config))
+	engine.GET("/panic", func(c *gin.Context) {
+		panic("test only")
+	})
+	engine.GET("/teapot", func(c *gin.Context) {
+		ErrorResponse(c, 418, nil)
+	})
+	api := engine.Group("/api", ForceErrorFormat(ErrorFormatJSON))
+	api.GET("/panic", func(c *gin.Context) {
+		panic("test only")
+	})
+	text := engine.Group("/text", ForceErrorFormat(ErrorFormatText))
+	text.GET("/panic", func(c *gin.Context) {
+		panic("test only")
+	})
+	html500, _ := os.ReadFile("testdata/templates/error/500.html")
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"browser", "/panic", "text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8", string(html500)},
+		{"any", "/panic", "*/*", "text/plain; charset=utf-8", "500 Internal Server Error"},
+		{"no accept", "/panic", "", "text/plain; charset=utf-8", "500 Internal Server Error"},
+		{"any text", "/panic", "text/*", "text/plain; charset=utf-8", "500 Internal Server Error"},
+		{"json client", "/panic", "application/json", problemContentType, ""},
+		{"problem client", "/panic", "application/problem+json", problemContentType, ""},
+		{"text client", "/panic", "text/plain", "text/plain; charset=utf-8", "500 Internal Server Error"},
+		{"forced json", "/api/panic", "text/html", problemContentType, ""},
+		{"forced text", "/text/panic", "text/html", "text/plain; charset=utf-8", "500 Internal Server Error"},
+		{"no template", "/teapot", "text/html", "text/plain; charset=utf-8", "418 I'm a teapot"},
+	}
+	for _, mode := range []string{gin.ReleaseMode, gin.DebugMode} {
+		gin.SetMode(mode)
+		for _, tt := range tests {
+			t.Run(mode+" "+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest("GET", tt.path, nil)
+				req.Header.Set("Accept", tt.accept)
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, req)
+				assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+				if mode == gin.DebugMode && tt.name == "browser" {
+					assert.Contains(t, w.Body.String(), "<h1>500 Internal Server Error")
+					return
+				}
+				if tt.contentType != problemContentType {
+					if mode == gin.DebugMode && tt.body != string(html500) && tt.path != "/teapot" {
+						assert.Equal(t, tt.body+": test only", w.Body.String())
+					} else {
+						assert.Equal(t, tt.body, w.Body.String())
+					}
+					return
+				}
+				var p Problem
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &p))
+				assert.Equal(t, 500, p.Status)
+				assert.Equal(t, "Internal Server Error", p.Title)
+				assert.Equal(t, tt.path, p.Instance)
+				assert.Equal(t, w.Header().Get(RequestIDHeader), p.RequestID)
+				if mode == gin.DebugMode {
+					assert.Equal(t, "test only", p.Detail)
+				} else {
+					assert.Empty(t, p.Detail)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// redactedValue replaces every sensitive value in logs and dumps
+const redactedValue = "*"
+
+var (
+	defaultRedactHeaders = []string{
+		"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+		"X-Api-Key", "X-Auth-Token", "X-Csrf-Token",
+	}
+	defaultRedactQuery = []string{
+		"token", "access_token", "refresh_token", "api_key", "apikey", "password", "secret",
+	}
+	defaultRedactFields = []string{
+		"password", "token", "access_token", "refresh_token", "secret", "api_key",
+	}
+)
+
+// Redactor masks sensitive headers, query parameters and JSON body fields before
+// they are written to any log. All names are matched case-insensitively.
+type Redactor struct {
+	headers map[string]struct{}
+	query   map[string]struct{}
+	fields  map[string]struct{}
+}
+
+var defaultRedactor = NewRedactor(nil, nil, nil)
+
+// NewRedactor create a Redactor, the given names are added to the default list
+func NewRedactor(headers, query, fields []string) *Redactor {
+	return &Redactor{
+		headers: nameSet(defaultRedactHeaders, headers),
+		query:   nameSet(defaultRedactQuery, query),
+		fields:  nameSet(defaultRedactFields, fields),
+	}
+}
+
+func nameSet(lists ...[]string) map[string]struct{} {
+	ret := map[string]struct{}{}
+	for _, list := range lists {
+		for _, name := range list {
+			ret[strings.ToLower(name)] = struct{}{}
+		}
+	}
+	return ret
+}
+
+func (r *Redactor) has(set map[string]struct{}, name string) bool {
+	_, ok := set[strings.ToLower(name)]
+	return ok
+}
+
+// Header return a copy of h with the sensitive values masked
+func (r *Redactor) Header(h http.Header) http.Header {
+	ret := make(http.Header, len(h))
+	for k, v := range h {
+		if r.has(r.headers, k) {
+			ret[k] = []string{redactedValue}
+			continue
+		}
+		ret[k] = append([]string(nil), v...)
+	}
+	return ret
+}
+
+// Query return the raw query with the sensitive values masked, the order of
+// parameters is kept
+func (r *Redactor) Query(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	parts := strings.Split(raw, "&")
+	for idx, part := range parts {
+		key, _, found := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if found && r.has(r.query, name) {
+			parts[idx] = key + "=" + redactedValue
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// URL return the request uri of u with the sensitive query values masked
+func (r *Redactor) URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	ret := *u
+	ret.RawQuery = r.Query(u.RawQuery)
+	ret.User = nil
+	return ret.RequestURI()
+}
+
+// JSON return body with the sensitive fields masked at any depth.
+// Body which is not valid JSON is returned unchanged.
+func (r *Redactor) JSON(body []byte) []byte {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return body
+	}
+	buf, err := json.Marshal(r.value(v))
+	if err != nil {
+		return body
+	}
+	return buf
+}
+
+func (r *Redactor) value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range t {
+			if r.has(r.fields, k) {
+				t[k] = redactedValue
+			} else {
+				t[k] = r.value(vv)
+			}
+		}
+	case []interface{}:
+		for idx, vv := range t {
+			t[idx] = r.value(vv)
+		}
+	}
+	return v
+}
+
+// DumpRequest return the request line and headers of req with all the sensitive
+// values masked, it is used by the recovery log
+func (r *Redactor) DumpRequest(req *http.Request) string {
+	clone := req.Clone(req.Context())
+	clone.Header = r.Header(req.Header)
+	clone.URL.RawQuery = r.Query(req.URL.RawQuery)
+	clone.URL.User = nil
+	clone.RequestURI = ""
+	clone.Body = nil
+	buf, err := httputil.DumpRequest(clone, false)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(strings.ReplaceAll(string(buf), "\r\n", "\n"), "\n")
+}
+
+func parseRedactor(m interface{}) (*Redactor, error) {
+	var lists [3][]string
+	for idx, name := range []string{"headers", "query", "json"} {
+		mm, err := extract(m, name)
+		if err != nil {
+			continue
+		}
+		list, err := stringList(mm)
+		if err != nil {
+			return nil, err
+		}
+		lists[idx] = list
+	}
+	return NewRedactor(lists[0], lists[1], lists[2]), nil
+}
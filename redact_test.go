@@ -0,0 +1,90 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor([]string{"x-session"}, []string{"SIG"}, []string{"ssn"})
+	t.Run("header", func(t *testing.T) {
+		h := http.Header{}
+		h.Set("Authorization", "Bearer abc")
+		h.Set("proxy-authorization", "Basic abc")
+		h.Set("Cookie", "id=1")
+		h.Set("X-API-KEY", "k")
+		h.Set("X-Session", "s")
+		h.Set("Accept", "text/html")
+		got := r.Header(h)
+		for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", "X-Session"} {
+			assert.Equal(t, redactedValue, got.Get(name), name)
+		}
+		assert.Equal(t, "text/html", got.Get("Accept"))
+		assert.Equal(t, "Bearer abc", h.Get("Authorization"), "original must not change")
+	})
+	t.Run("query", func(t *testing.T) {
+		assert.Equal(t, "a=1&Token=*&sig=*&b", r.Query("a=1&Token=secret&sig=x&b"))
+		assert.Equal(t, "access%5Ftoken=*", r.Query("access%5Ftoken=abc"))
+		assert.Equal(t, "", r.Query(""))
+	})
+	t.Run("json", func(t *testing.T) {
+		got := r.JSON([]byte(`{"user":"u","Password":"p","nested":[{"SSN":"1","id":12345678901234567890}]}`))
+		assert.JSONEq(t, `{"user":"u","Password":"*","nested":[{"SSN":"*","id":12345678901234567890}]}`, string(got))
+		assert.Equal(t, "not json", string(r.JSON([]byte("not json"))))
+	})
+}
+
+func TestParseRedactor(t *testing.T) {
+	var out interface{}
+	assert.Nil(t, yaml.Unmarshal([]byte("headers: [X-Token]\nquery: [sig]\njson: [pin]"), &out))
+	r, err := parseRedactor(out)
+	assert.Nil(t, err)
+	assert.True(t, r.has(r.headers, "x-token"))
+	assert.True(t, r.has(r.headers, "authorization"))
+	assert.True(t, r.has(r.query, "Sig"))
+	assert.True(t, r.has(r.fields, "PIN"))
+
+	assert.Nil(t, yaml.Unmarshal([]byte("headers: X-Token"), &out))
+	_, err = parseRedactor(out)
+	assert.NotNil(t, err)
+}
+
+func TestRecoveryRedaction(t *testing.T) {
+	gin.SetMode(gin.DebugMode)
+	var buf bytes.Buffer
+	config := initConfig()
+	config.errlog = zerolog.New(&buf)
+	engine := gin.New()
+	engine.Use(ginRecovery(config))
+	engine.POST("/", func(c *gin.Context) {
+		var v map[string]interface{}
+		_ = c.ShouldBindBodyWith(&v, binding.JSON)
+		panic("test only")
+	})
+	req := httptest.NewRequest("POST", "/?token=abc&page=2", strings.NewReader(`{"password":"hunter2"}`))
+	req.Header.Set("authorization", "Bearer abc")
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Set("X-Api-Key", "abc")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	assert.Equal(t, 500, w.Code)
+	out := buf.String()
+	assert.NotContains(t, out, "abc")
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, "page=2")
+	assert.Contains(t, out, `\"password\":\"*\"`)
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-errors/errors"
+)
+
+// BuildVersion is the version of the application put in every PanicReport,
+// set it when build: go build -ldflags "-X github.com/cytown/gintool.BuildVersion=v1.0.0"
+var BuildVersion = "dev"
+
+// StackFrame is one frame of the panic stack
+type StackFrame struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Package  string `json:"package"`
+	Function string `json:"function"`
+}
+
+// PanicReport is the structured information of a recovered panic
+type PanicReport struct {
+	Time        time.Time    `json:"time"`
+	Error       string       `json:"error"`
+	Type        string       `json:"type"`
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	Headers     http.Header  `json:"headers"`
+	Stack       []StackFrame `json:"stack"`
+	RequestID   string       `json:"request_id"`
+	SessionKeys []string     `json:"session_keys"`
+	Version     string       `json:"version"`
+}
+
+// ErrorReporter receive the report of every recovered panic.
+// Report is called in its own goroutine, the returned error is written to the error log
+type ErrorReporter interface {
+	Report(report *PanicReport) error
+}
+
+// AddErrorReporter register an ErrorReporter to the engine, it should be
+// called before Start
+func (ge *GinEngine) AddErrorReporter(r ErrorReporter) {
+	ge.config.reportersMu.Lock()
+	defer ge.config.reportersMu.Unlock()
+	ge.config.reporters = append(ge.config.reporters, r)
+}
+
+func newPanicReport(c *gin.Context, config *Config, stack *errors.Error) *PanicReport {
+	redactor := config.Redactor()
+	report := &PanicReport{
+		Time:      time.Now(),
+		Error:     stack.Error(),
+		Type:      stack.TypeName(),
+		Method:    c.Request.Method,
+		URL:       redactor.URL(c.Request.URL),
+		Headers:   redactor.Header(c.Request.Header),
+		RequestID: RequestID(c),
+		Version:   BuildVersion,
+	}
+	for _, frame := range stack.StackFrames() {
+		report.Stack = append(report.Stack, StackFrame{
+			File:     frame.File,
+			Line:     frame.LineNumber,
+			Package:  frame.Package,
+			Function: frame.Name,
+		})
+	}
+	if s := FromGin(c); s != nil {
+		report.SessionKeys = s.Keys()
+	}
+	return report
+}
+
+func (c *Config) report(report *PanicReport) {
+	c.reportersMu.RLock()
+	reporters := c.reporters
+	c.reportersMu.RUnlock()
+	for _, r := range reporters {
+		go func(r ErrorReporter) {
+			if err := r.Report(report); err != nil {
+				c.errlog.Error().Msgf("[Recovery] report %s failed: %v", report.RequestID, err)
+			}
+		}(r)
+	}
+}
+
+// FileReporter write every PanicReport as a JSON file in Dir
+type FileReporter struct {
+	Dir string
+}
+
+// NewFileReporter create a FileReporter, dir will be created if not exist
+func NewFileReporter(dir string) *FileReporter {
+	return &FileReporter{Dir: dir}
+}
+
+// Report write the report to file panic-<time>-<random>.json, the request id
+// is only in the content
+func (r *FileReporter) Report(report *PanicReport) error {
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("panic-%s-%s.json", report.Time.UTC().Format("20060102T150405.000000000"), randomHex(4))
+	return os.WriteFile(filepath.Join(r.Dir, name), buf, 0644)
+}
+
+// WebhookReporter POST every PanicReport as JSON to URL
+type WebhookReporter struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookReporter create a WebhookReporter with 10 seconds timeout
+func NewWebhookReporter(url string) *WebhookReporter {
+	return &WebhookReporter{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Report post the report, any status other than 2xx is an error
+func (r *WebhookReporter) Report(report *PanicReport) error {
+	buf, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	res, err := r.Client.Post(r.URL, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s return %s", r.URL, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type reporterFunc func(report *PanicReport) error
+
+func (f reporterFunc) Report(report *PanicReport) error {
+	return f(report)
+}
+
+func panicEngine(config *Config) *GinEngine {
+	ge := &GinEngine{Engine: gin.New(), config: config}
+	ge.Engine.Use(UseRequestID(), ginRecovery(config), UseSession(config))
+	ge.Engine.GET("/panic", func(c *gin.Context) {
+		SessionSet("user", "u1")
+		panic("test only")
+	})
+	return ge
+}
+
+func TestErrorReporter(t *testing.T) {
+	config := initConfig()
+	ge := panicEngine(config)
+	ch := make(chan *PanicReport, 1)
+	ge.AddErrorReporter(reporterFunc(func(report *PanicReport) error {
+		ch <- report
+		return nil
+	}))
+	req := httptest.NewRequest("GET", "/panic?token=abc", nil)
+	req.Header.Set("Authorization", "secret")
+	req.Header.Set(RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	ge.Engine.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+	assert.Equal(t, "req-1", w.Header().Get(RequestIDHeader))
+
+	select {
+	case report := <-ch:
+		assert.Equal(t, "test only", report.Error)
+		assert.Equal(t, "GET", report.Method)
+		assert.Equal(t, "/panic?token=*", report.URL)
+		assert.Equal(t, "*", report.Headers.Get("Authorization"))
+		assert.Equal(t, "req-1", report.RequestID)
+		assert.Equal(t, BuildVersion, report.Version)
+		assert.Contains(t, report.SessionKeys, "user")
+		assert.NotEmpty(t, report.Stack)
+	case <-time.After(time.Second):
+		t.Fatal("report not received")
+	}
+}
+
+func TestFileReporter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "panics")
+	config := initConfig()
+	ge := panicEngine(config)
+	ge.AddErrorReporter(NewFileReporter(dir))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set(RequestIDHeader, "/../../escaped")
+	ge.Engine.ServeHTTP(w, req)
+
+	var files []string
+	for i := 0; i < 100 && len(files) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		files, _ = filepath.Glob(filepath.Join(dir, "panic-*.json"))
+	}
+	if assert.Len(t, files, 1) {
+		buf, err := os.ReadFile(files[0])
+		assert.Nil(t, err)
+		var report PanicReport
+		assert.Nil(t, json.Unmarshal(buf, &report))
+		assert.Equal(t, "test only", report.Error)
+		assert.Equal(t, w.Header().Get(RequestIDHeader), report.RequestID)
+		assert.NotContains(t, files[0], "escaped")
+	}
+	assert.NotEqual(t, "/../../escaped", w.Header().Get(RequestIDHeader))
+	_, err := os.Stat(filepath.Join(dir, "..", "..", "escaped.json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRequestID(t *testing.T) {
+	engine := gin.New()
+	engine.Use(UseRequestID())
+	engine.GET("/", func(c *gin.Context) {
+		c.String(200, RequestID(c))
+	})
+	for id, keep := range map[string]bool{
+		"req-1":                 true,
+		"a.b_c-D9":              true,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+		"/../../escaped":        false,
+		"a b":                   false,
+		"id\\x":                 false,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, id)
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, keep, w.Body.String() == id, id)
+		assert.Regexp(t, `^[A-Za-z0-9._-]{1,64}$`, w.Body.String())
+	}
+}
+
+func TestWebhookReporter(t *testing.T) {
+	ch := make(chan PanicReport, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var report PanicReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ch <- report
+	}))
+	defer server.Close()
+
+	config := initConfig()
+	ge := panicEngine(config)
+	ge.AddErrorReporter(NewWebhookReporter(server.URL + "/"))
+	ge.Engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	select {
+	case report := <-ch:
+		assert.Equal(t, "/panic", report.URL)
+	case <-time.After(time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	assert.NotNil(t, NewWebhookReporter(server.URL+"/404").Report(&PanicReport{}))
+}
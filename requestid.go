@@ -0,0 +1,60 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader is the header used to read and return the request id
+const RequestIDHeader = "X-Request-Id"
+
+const requestIDKey = "_request_id_"
+
+// validRequestID is the format of the request ids accepted from the clients
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// UseRequestID is a middleware which take the request id from the request
+// header or generate a new one, and return it in the response header.
+// GinEngine default will use this middleware
+func UseRequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		RequestID(c)
+		c.Next()
+	}
+}
+
+// RequestID return the id of current request, it is generated if not exist
+// or if the id of the header has other characters than letters, digits, dot,
+// underscore and dash, or is longer than 64
+func RequestID(c *gin.Context) string {
+	if id := c.GetString(requestIDKey); id != "" {
+		return id
+	}
+	id := c.GetHeader(RequestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+func newRequestID() string {
+	return randomHex(16)
+}
+
+// randomHex return n random bytes in hex, empty if the random source failed
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
@@ -5,6 +5,12 @@
 package gintool
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
 	"github.com/gin-gonic/gin"
 	"github.com/v2pro/plz/gls"
 )
@@ -12,12 +18,83 @@ import (
 const session_name string = "_session_"
 const config_name string = "_config_"
 
-// Session can be used in app started with WithSession or UseSession
+// Session can be used in app started with WithSession or UseSession.
+// When the session is configured in gin.conf, the values are loaded from the
+// SessionStore by the session cookie and saved back before the response is written.
 type Session struct {
-	data map[string]interface{}
+	mu    sync.RWMutex
+	data  map[string]interface{}
+	local map[string]interface{}
+
+	id        string
+	manager   *sessionManager
+	ctx       *gin.Context
+	isNew     bool
+	modified  bool
+	destroyed bool
+	detached  bool
+}
+
+// ErrSessionDetached is returned by Save, Destroy and RegenerateID after the
+// end of the request, such as in the goroutines started by Go
+var ErrSessionDetached = errors.New("session is detached from the request")
+
+type sessionContextKey struct{}
+
+// FromContext return the Session stored in ctx by UseSession, nil if not exist.
+// The request context of gin (c.Request.Context()) and its children carry the session.
+func FromContext(ctx context.Context) *Session {
+	if c, ok := ctx.(*gin.Context); ok {
+		return FromGin(c)
+	}
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return s
+}
+
+// FromGin return the Session of the request, nil if UseSession is not used
+func FromGin(c *gin.Context) *Session {
+	if v, ok := c.Get(session_name); ok {
+		return v.(*Session)
+	}
+	if c.Request == nil {
+		return nil
+	}
+	return FromContext(c.Request.Context())
+}
+
+// ContextWithSession return a copy of ctx which carry s
+func ContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, s)
+}
+
+// Go run f in a new goroutine with the session of ctx. The package level
+// functions such as SessionGet also work in f.
+// A *gin.Context is copied before passed to f, as it can't be used after the request.
+// The session is detached at the end of the request, so f may only read it:
+// the values set are not saved, and Save, Destroy and RegenerateID return
+// ErrSessionDetached. Use the SessionStore to write from f.
+func Go(ctx context.Context, f func(ctx context.Context)) {
+	if c, ok := ctx.(*gin.Context); ok {
+		ctx = c.Copy()
+	}
+	s := FromContext(ctx)
+	if s == nil {
+		go f(ctx)
+		return
+	}
+	go gls.WithGls(func() {
+		gls.Set(session_name, s)
+		f(ctx)
+	})()
 }
 
-// GetSession get the Session, if not exist return new
+// GetSession get the Session of current goroutine, nil if not exist.
+//
+// Deprecated: the goroutine local session is lost in the goroutines started
+// by the handler, use FromGin or FromContext instead.
 func GetSession() *Session {
 	if v := gls.Get(session_name); v != nil {
 		return v.(*Session)
@@ -30,25 +107,151 @@ func NewSession() *Session {
 	gls.GoID()
 	var s *Session
 	if s = GetSession(); s == nil {
-		s = &Session{
-			data: map[string]interface{}{},
-		}
+		s = newSession()
 		gls.Set(session_name, s)
 	}
 	return s
 }
 
-// SessionGet return the value stored in Session
+func newSession() *Session {
+	return &Session{
+		data:  map[string]interface{}{},
+		local: map[string]interface{}{},
+	}
+}
+
+// SessionGet return the value stored in Session of current goroutine, nil if
+// there is no session.
+//
+// Deprecated: use FromGin(c).Get or FromContext(ctx).Get instead.
 func SessionGet(name string) interface{} {
 	s := GetSession()
-	return s.data[name]
+	if s == nil {
+		return nil
+	}
+	return s.Get(name)
 }
 
-// SessionSet store the value to Session
+// SessionSet store the value to Session of current goroutine, it does nothing
+// if there is no session.
+//
+// Deprecated: use FromGin(c).Set or FromContext(ctx).Set instead.
 func SessionSet(name string, val interface{}) {
-	s := GetSession()
+	if s := GetSession(); s != nil {
+		s.Set(name, val)
+	}
+}
+
+// ID return the id of the persistent session, empty if the session is not configured
+func (s *Session) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.id
+}
+
+// Get return the value stored in Session
+func (s *Session) Get(name string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data[name]
+}
+
+// Set store the value to Session
+func (s *Session) Set(name string, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.data[name] = val
-	//glsSetSession(s)
+	s.modified = true
+}
+
+// Delete remove the value from Session
+func (s *Session) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[name]; ok {
+		delete(s.data, name)
+		s.modified = true
+	}
+}
+
+// Keys return the sorted keys stored in Session
+func (s *Session) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// values return a copy of the stored values
+func (s *Session) values() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make(map[string]interface{}, len(s.data))
+	for k, v := range s.data {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Save write the session to the SessionStore and set the cookie if the response
+// is not written yet. It is called automatically by UseSession.
+func (s *Session) Save() error {
+	m, err := s.sessionManager()
+	if m == nil {
+		return err
+	}
+	return m.save(s)
+}
+
+// Destroy delete the session from the SessionStore and expire the cookie
+func (s *Session) Destroy() error {
+	m, err := s.sessionManager()
+	if m == nil {
+		return err
+	}
+	return m.destroy(s)
+}
+
+// RegenerateID keep the values but move them to a new session id, it should be
+// called after login to prevent session fixation
+func (s *Session) RegenerateID() error {
+	m, err := s.sessionManager()
+	if m == nil {
+		return err
+	}
+	return m.regenerate(s)
+}
+
+// sessionManager return the manager of the request, ErrSessionDetached after
+// the request
+func (s *Session) sessionManager() (*sessionManager, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.detached {
+		return nil, ErrSessionDetached
+	}
+	return s.manager, nil
+}
+
+// context return the gin context of the request, nil after the request
+func (s *Session) context() *gin.Context {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ctx
+}
+
+// detach forget the request once the session is committed, the writer of
+// the request may be reused by another one
+func (s *Session) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = nil
+	s.manager = nil
+	s.detached = true
 }
 
 // UseSession is a middleware which generate the session in gorouting
@@ -57,9 +260,21 @@ func SessionSet(name string, val interface{}) {
 func UseSession(config *Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		WithSession(func() {
-			config.stdlog.Debug().Msgf("session initialed %v %v", gls.GoID(), config)
-			SessionSet(config_name, &config)
+			config.stdlog.Debug().Msgf("session initialed %v", gls.GoID())
+			s := GetSession()
+			s.SetLocal(config_name, config)
+			c.Set(session_name, s)
+			c.Request = c.Request.WithContext(ContextWithSession(c.Request.Context(), s))
+			m := config.session
+			if m != nil {
+				m.load(c, s)
+				c.Writer = &sessionWriter{ResponseWriter: c.Writer, session: s}
+			}
 			c.Next()
+			if m != nil {
+				m.commit(s)
+				s.detach()
+			}
 		})()
 	}
 }
@@ -74,10 +289,66 @@ func WithSession(f func()) func() {
 
 // SessionConfig return the saved *Config, it must be called after Use(UseSession(*Config))
 func SessionConfig() *Config {
-	t := SessionGet(config_name)
-	tt, ok := t.(**Config)
-	if ok {
-		return *tt
+	return GetSession().Config()
+}
+
+// Config return the *Config saved by UseSession
+func (s *Session) Config() *Config {
+	if s == nil {
+		return nil
 	}
-	return nil
+	config, _ := s.Local(config_name).(*Config)
+	return config
+}
+
+// SetLocal store a value for the current request only, it is never saved
+func (s *Session) SetLocal(name string, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.local[name] = val
+}
+
+// Local return the value stored by SetLocal
+func (s *Session) Local(name string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.local[name]
+}
+
+// sessionWriter commit the session before the header is written, so the
+// cookie can still be set
+type sessionWriter struct {
+	gin.ResponseWriter
+	session *Session
+}
+
+// Unwrap return the original writer
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *sessionWriter) beforeWrite() {
+	if !w.ResponseWriter.Written() {
+		w.session.manager.commit(w.session)
+	}
+}
+
+func (w *sessionWriter) WriteHeaderNow() {
+	w.beforeWrite()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.beforeWrite()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sessionWriter) WriteString(s string) (int, error) {
+	w.beforeWrite()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *sessionWriter) Flush() {
+	w.beforeWrite()
+	w.ResponseWriter.Flush()
 }
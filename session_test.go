@@ -0,0 +1,110 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionContext(t *testing.T) {
+	config := initConfig()
+	engine := gin.New()
+	engine.Use(UseSession(config))
+	var wg sync.WaitGroup
+	engine.GET("/", func(c *gin.Context) {
+		s := FromGin(c)
+		assert.NotNil(t, s)
+		assert.Same(t, s, FromContext(c))
+		assert.Same(t, s, FromContext(c.Request.Context()))
+		assert.Same(t, s, GetSession())
+		assert.Same(t, config, s.Config())
+		s.Set("user", "u1")
+		assert.Equal(t, "u1", SessionGet("user"), "package level functions still work")
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		wg.Add(3)
+		Go(ctx, func(ctx context.Context) {
+			defer wg.Done()
+			assert.Same(t, s, FromContext(ctx))
+			assert.Equal(t, "u1", SessionGet("user"))
+			SessionSet("child", true)
+		})
+		Go(c, func(ctx context.Context) {
+			defer wg.Done()
+			assert.Same(t, s, FromContext(ctx))
+		})
+		Go(context.Background(), func(ctx context.Context) {
+			defer wg.Done()
+			assert.Nil(t, FromContext(ctx))
+			assert.Nil(t, SessionGet("user"))
+		})
+		wg.Wait()
+		assert.Equal(t, true, s.Get("child"))
+		c.String(200, "ok")
+	})
+	w := doRequest(engine, "GET", "/")
+	assert.Equal(t, "ok", w.Body.String())
+}
+
+func TestSessionOutsideRequest(t *testing.T) {
+	assert.Nil(t, GetSession())
+	assert.Nil(t, SessionGet("user"), "must not panic without session")
+	SessionSet("user", "u1")
+	assert.Nil(t, SessionConfig())
+	assert.Nil(t, FromContext(context.Background()))
+	c, _ := gin.CreateTestContext(nil)
+	assert.Nil(t, FromGin(c))
+
+	s := newSession()
+	ctx := ContextWithSession(context.Background(), s)
+	assert.Same(t, s, FromContext(ctx))
+}
+
+func TestSessionDetachedInGo(t *testing.T) {
+	store := NewMemoryStore(0)
+	engine := sessionEngine(t, store)
+	release, done := make(chan struct{}), make(chan error, 1)
+	engine.GET("/async", func(c *gin.Context) {
+		FromGin(c).Set("user", "tom")
+		Go(c, func(ctx context.Context) {
+			<-release
+			s := FromContext(ctx)
+			assert.Equal(t, "tom", s.Get("user"))
+			s.Set("user", "jerry")
+			err := s.Save()
+			if err == nil {
+				err = s.RegenerateID()
+			}
+			if err == nil {
+				err = s.Destroy()
+			}
+			done <- err
+		})
+		c.String(200, "ok")
+	})
+	engine.GET("/other", func(c *gin.Context) {
+		// the goroutine of the previous request save while this one is served
+		close(release)
+		assert.Equal(t, ErrSessionDetached, <-done)
+		c.String(200, "other")
+	})
+
+	w := doRequest(engine, "GET", "/async")
+	cookie := sessionCookie(w)
+	if !assert.NotNil(t, cookie) {
+		return
+	}
+	w = doRequest(engine, "GET", "/other")
+	assert.Equal(t, "other", w.Body.String())
+	assert.Empty(t, w.Header().Values("Set-Cookie"), "the cookie of another session is not sent")
+	w = doRequest(engine, "GET", "/get", cookie)
+	assert.Equal(t, "tom", w.Body.String(), "the values set after the request are not saved")
+}
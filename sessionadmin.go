@@ -0,0 +1,179 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const sessionUserKey = "_user_id_"
+
+// ErrListNotSupported is returned if the SessionStore can't list the sessions
+var ErrListNotSupported = errors.New("session store can't list the sessions")
+
+// SetUserID save the id of the logged in user, the sessions are indexed by it
+// so they can be listed and revoked by user
+func (s *Session) SetUserID(id string) {
+	if id == "" {
+		s.Delete(sessionUserKey)
+		return
+	}
+	s.Set(sessionUserKey, id)
+}
+
+// UserID return the id saved by SetUserID
+func (s *Session) UserID() string {
+	id, _ := s.Get(sessionUserKey).(string)
+	return id
+}
+
+func sessionUserID(values map[string]interface{}) string {
+	id, _ := values[sessionUserKey].(string)
+	return id
+}
+
+// SessionInfo is the summary of a stored session
+type SessionInfo struct {
+	ID      string    `json:"id"`
+	UserID  string    `json:"user_id,omitempty"`
+	Expires time.Time `json:"expires"`
+	Keys    []string  `json:"keys"`
+}
+
+func newSessionInfo(id string, expires time.Time, values map[string]interface{}) SessionInfo {
+	info := SessionInfo{ID: id, UserID: sessionUserID(values), Expires: expires, Keys: []string{}}
+	for k := range values {
+		info.Keys = append(info.Keys, k)
+	}
+	sort.Strings(info.Keys)
+	return info
+}
+
+func sortSessions(sessions []SessionInfo) {
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Expires.Before(sessions[j].Expires)
+	})
+}
+
+// SessionFilter select the sessions of List, the empty fields match all
+type SessionFilter struct {
+	UserID string
+}
+
+func (f SessionFilter) match(info *SessionInfo) bool {
+	return f.UserID == "" || f.UserID == info.UserID
+}
+
+// SessionLister is implemented by the stores which can list and revoke the
+// sessions, the MemoryStore and the FileStore. The CookieStore can't as the
+// sessions are only kept by the browsers.
+type SessionLister interface {
+	List(filter SessionFilter) ([]SessionInfo, error)
+	Revoke(id string) error
+}
+
+// RevokeUser revoke all the sessions of the user, return the count revoked
+func RevokeUser(store SessionStore, userID string) (int, error) {
+	lister, ok := store.(SessionLister)
+	if !ok {
+		return 0, ErrListNotSupported
+	}
+	sessions, err := lister.List(SessionFilter{UserID: userID})
+	if err != nil {
+		return 0, err
+	}
+	for idx, info := range sessions {
+		if err := lister.Revoke(info.ID); err != nil {
+			return idx, err
+		}
+	}
+	return len(sessions), nil
+}
+
+// MountSessionAdmin add the JSON routes to list and revoke the sessions:
+//
+//	GET    <prefix>?user=<id>   list the sessions, of the user if given
+//	DELETE <prefix>/<id>        revoke the session
+//	DELETE <prefix>?user=<id>   revoke all the sessions of the user
+//
+// The routes are protected by the auth rules, an error is returned if no
+// rule match the prefix.
+func (ge *GinEngine) MountSessionAdmin(prefix string) error {
+	c := ge.config
+	if c.session == nil {
+		return fmt.Errorf("session is not configured")
+	}
+	if !c.accessProtected(prefix) {
+		return fmt.Errorf("session admin routes %s are not protected by any auth rule", prefix)
+	}
+	group := ge.Engine.Group(prefix, ForceErrorFormat(ErrorFormatJSON))
+	lister := func(cc *gin.Context) SessionLister {
+		lister, ok := c.session.store.(SessionLister)
+		if !ok {
+			ErrorResponse(cc, http.StatusNotImplemented, ErrListNotSupported)
+		}
+		return lister
+	}
+	group.GET("", func(cc *gin.Context) {
+		l := lister(cc)
+		if l == nil {
+			return
+		}
+		sessions, err := l.List(SessionFilter{UserID: cc.Query("user")})
+		if err != nil {
+			ErrorResponse(cc, http.StatusInternalServerError, err)
+			return
+		}
+		cc.JSON(http.StatusOK, gin.H{"sessions": sessions})
+	})
+	group.DELETE("", func(cc *gin.Context) {
+		user := cc.Query("user")
+		if user == "" {
+			ErrorResponse(cc, http.StatusBadRequest, fmt.Errorf("user is required"))
+			return
+		}
+		n, err := RevokeUser(c.session.store, user)
+		if errors.Is(err, ErrListNotSupported) {
+			ErrorResponse(cc, http.StatusNotImplemented, err)
+			return
+		}
+		if err != nil {
+			ErrorResponse(cc, http.StatusInternalServerError, err)
+			return
+		}
+		c.stdlog.Warn().Str("request_id", RequestID(cc)).Str("by", AccessName(cc)).
+			Msgf("[Session] revoked %d sessions of user %s", n, user)
+		cc.JSON(http.StatusOK, gin.H{"revoked": n})
+	})
+	group.DELETE("/:id", func(cc *gin.Context) {
+		l := lister(cc)
+		if l == nil {
+			return
+		}
+		if err := l.Revoke(cc.Param("id")); err != nil {
+			ErrorResponse(cc, http.StatusInternalServerError, err)
+			return
+		}
+		c.stdlog.Warn().Str("request_id", RequestID(cc)).Str("by", AccessName(cc)).
+			Msg("[Session] revoked a session")
+		cc.JSON(http.StatusOK, gin.H{"revoked": 1})
+	})
+	return nil
+}
+
+// accessProtected return true if an auth rule match the path
+func (c *Config) accessProtected(path string) bool {
+	rules := c.access.Load()
+	if rules == nil {
+		return false
+	}
+	return rules.match(path) != nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminEngine(t *testing.T, store SessionStore) *gin.Engine {
+	engine := sessionEngine(t, store)
+	config := initConfig()
+	config.session = &sessionManager{store: store}
+	config.access.Store(&accessRules{rules: []*accessRule{{prefix: "/admin"}}})
+	ge := &GinEngine{Engine: engine, config: config}
+	assert.Nil(t, ge.MountSessionAdmin("/admin/sessions"))
+	engine.GET("/login", func(c *gin.Context) {
+		FromGin(c).SetUserID(c.Query("user"))
+		c.String(200, "ok")
+	})
+	engine.GET("/whoami", func(c *gin.Context) {
+		c.String(200, FromGin(c).UserID())
+	})
+	return engine
+}
+
+func listSessions(t *testing.T, engine *gin.Engine, query string) []SessionInfo {
+	w := doRequest(engine, "GET", "/admin/sessions"+query)
+	assert.Equal(t, 200, w.Code)
+	var res struct {
+		Sessions []SessionInfo `json:"sessions"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	return res.Sessions
+}
+
+func TestSessionAdmin(t *testing.T) {
+	for name, store := range map[string]SessionStore{
+		"memory": NewMemoryStore(0),
+		"file":   mustFileStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := adminEngine(t, store)
+			var cookies []*http.Cookie
+			for _, user := range []string{"tom", "tom", "jerry"} {
+				cookies = append(cookies, sessionCookie(doRequest(engine, "GET", "/login?user="+user)))
+			}
+			doRequest(engine, "GET", "/set?user=anonymous")
+
+			assert.Len(t, listSessions(t, engine, ""), 4)
+			toms := listSessions(t, engine, "?user=tom")
+			if !assert.Len(t, toms, 2) {
+				return
+			}
+			assert.Equal(t, "tom", toms[0].UserID)
+			assert.Equal(t, []string{sessionUserKey}, toms[0].Keys)
+			assert.True(t, toms[0].Expires.After(time.Now()))
+
+			w := doRequest(engine, "DELETE", "/admin/sessions/"+toms[0].ID)
+			assert.JSONEq(t, `{"revoked":1}`, w.Body.String())
+			assert.Len(t, listSessions(t, engine, "?user=tom"), 1)
+
+			w = doRequest(engine, "DELETE", "/admin/sessions?user=tom")
+			assert.JSONEq(t, `{"revoked":1}`, w.Body.String())
+			assert.Empty(t, listSessions(t, engine, "?user=tom"))
+			assert.Len(t, listSessions(t, engine, "?user=jerry"), 1)
+
+			// the revoked sessions are logged out
+			assert.Equal(t, "", doRequest(engine, "GET", "/whoami", cookies[1]).Body.String())
+			assert.Equal(t, "jerry", doRequest(engine, "GET", "/whoami", cookies[2]).Body.String())
+
+			w = doRequest(engine, "DELETE", "/admin/sessions")
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestSessionAdminCookieStore(t *testing.T) {
+	engine := adminEngine(t, NewCookieStore("test secret"))
+	w := doRequest(engine, "GET", "/admin/sessions")
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	_, err := RevokeUser(NewCookieStore("test secret"), "tom")
+	assert.Equal(t, ErrListNotSupported, err)
+}
+
+func TestSessionAdminUnprotected(t *testing.T) {
+	config := initConfig()
+	config.session = &sessionManager{store: NewMemoryStore(0)}
+	ge := &GinEngine{Engine: gin.New(), config: config}
+	assert.NotNil(t, ge.MountSessionAdmin("/admin/sessions"))
+	config.access.Store(&accessRules{rules: []*accessRule{{prefix: "/metrics"}}})
+	assert.NotNil(t, ge.MountSessionAdmin("/admin/sessions"))
+	assert.Empty(t, ge.Engine.Routes())
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "gin.conf")
+	assert.Nil(t, os.WriteFile(conf, []byte(`gin:
+  mode: test
+  session:
+    secret: test secret
+    store: memory
+    admin: /admin/sessions
+`), 0600))
+	level, mode := zerolog.GlobalLevel(), gin.Mode()
+	t.Cleanup(func() {
+		zerolog.SetGlobalLevel(level)
+		gin.SetMode(mode)
+	})
+	_, err := NewGin(conf)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "not protected")
+	}
+}
+
+func TestMemoryStoreIndex(t *testing.T) {
+	m := NewMemoryStore(2)
+	assert.Nil(t, m.Save("a", map[string]interface{}{sessionUserKey: "tom"}, time.Hour))
+	assert.Nil(t, m.Save("b", map[string]interface{}{sessionUserKey: "tom"}, time.Hour))
+	assert.Nil(t, m.Save("b", map[string]interface{}{sessionUserKey: "jerry"}, time.Hour))
+	list, _ := m.List(SessionFilter{UserID: "tom"})
+	assert.Len(t, list, 1)
+	// a is evicted by the size
+	assert.Nil(t, m.Save("c", map[string]interface{}{}, time.Hour))
+	list, _ = m.List(SessionFilter{UserID: "tom"})
+	assert.Empty(t, list)
+	assert.Empty(t, m.users["tom"])
+	assert.Nil(t, m.Save("d", map[string]interface{}{sessionUserKey: "tom"}, -time.Second))
+	list, _ = m.List(SessionFilter{UserID: "tom"})
+	assert.Empty(t, list, "expired session is not listed")
+}
+
+func TestFileStoreIndex(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	indexed := func(user, id string) bool {
+		_, err := os.Stat(filepath.Join(f.userDir(user), id))
+		return err == nil
+	}
+	assert.Nil(t, f.Save("a", map[string]interface{}{sessionUserKey: "tom"}, time.Hour))
+	assert.Nil(t, f.Save("b", map[string]interface{}{sessionUserKey: "tom"}, time.Hour))
+	assert.Nil(t, f.Save("b", map[string]interface{}{sessionUserKey: "jerry"}, time.Hour))
+	assert.True(t, indexed("jerry", "b"))
+	assert.False(t, indexed("tom", "b"))
+	list, _ := f.List(SessionFilter{UserID: "tom"})
+	assert.Len(t, list, 1)
+
+	// a session file without index is not read for the user
+	buf, err := encodeSession(&sessionRecord{ID: "c", Expires: time.Now().Add(time.Hour),
+		Values: map[string]interface{}{sessionUserKey: "tom"}})
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "c"+sessionFileSuffix), buf, 0600))
+	list, _ = f.List(SessionFilter{UserID: "tom"})
+	assert.Len(t, list, 1)
+	list, _ = f.List(SessionFilter{})
+	assert.Len(t, list, 3)
+
+	assert.Nil(t, f.Delete("a"))
+	assert.False(t, indexed("tom", "a"))
+	list, _ = f.List(SessionFilter{UserID: "tom"})
+	assert.Empty(t, list)
+
+	assert.Nil(t, f.Save("d", map[string]interface{}{sessionUserKey: "tom"}, -time.Second))
+	list, _ = f.List(SessionFilter{UserID: "tom"})
+	assert.Empty(t, list, "expired session is not listed")
+	assert.Nil(t, f.GC())
+	assert.False(t, indexed("tom", "d"))
+	_, err = os.Stat(f.userDir("tom"))
+	assert.True(t, os.IsNotExist(err), "empty index is removed by GC")
+
+	// the stale entry of a session removed by another way is dropped
+	assert.Nil(t, os.Remove(filepath.Join(dir, "b"+sessionFileSuffix)))
+	list, _ = f.List(SessionFilter{UserID: "jerry"})
+	assert.Empty(t, list)
+	assert.False(t, indexed("jerry", "b"))
+}
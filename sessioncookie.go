@@ -0,0 +1,133 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// maxCookieSize is the max size of a cookie value accepted by the browsers
+const maxCookieSize = 4000
+
+// cookieCodec encrypt and authenticate the cookie value with AES-GCM, the
+// cookie name is authenticated too so a value can't be moved to another cookie.
+// The value is encoded by the first secret and decoded by any of the secrets,
+// so a new secret can be put in front while the old ones are still accepted.
+type cookieCodec struct {
+	aeads []cipher.AEAD
+}
+
+func newCookieCodec(secrets ...string) *cookieCodec {
+	c := &cookieCodec{}
+	for _, secret := range secrets {
+		key := sha256.Sum256([]byte(secret))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			panic(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c
+}
+
+// Encode return the encrypted value for cookie name
+func (c *cookieCodec) Encode(name string, value string) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	buf := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Decode return the value of the cookie, error if it is forged or damaged
+func (c *cookieCodec) Decode(name string, value string) (string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	for _, aead := range c.aeads {
+		size := aead.NonceSize()
+		if len(buf) < size {
+			break
+		}
+		plain, err := aead.Open(nil, buf[:size], buf[size:], []byte(name))
+		if err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", fmt.Errorf("invalid cookie %s", name)
+}
+
+// CookieStore keep the whole session encrypted in the cookie, nothing is kept
+// in the server. The values are encoded with gob, the types only read must be
+// declared by NewKey or registered with gob.Register.
+// As the cookie is the session, Destroy and RegenerateID can't revoke a copy of
+// the old cookie before it expires.
+type CookieStore struct {
+	codec *cookieCodec
+}
+
+// NewCookieStore create a CookieStore, the first secret is used to encrypt and
+// all of them are used to decrypt for key rotation
+func NewCookieStore(secret string, oldSecrets ...string) *CookieStore {
+	return &CookieStore{codec: newCookieCodec(append([]string{secret}, oldSecrets...)...)}
+}
+
+// Load always return nil, the session is decoded from the cookie
+func (cs *CookieStore) Load(id string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// Save does nothing, the session is encoded into the cookie
+func (cs *CookieStore) Save(id string, values map[string]interface{}, ttl time.Duration) error {
+	return nil
+}
+
+// Delete does nothing, the cookie is expired by the session
+func (cs *CookieStore) Delete(id string) error {
+	return nil
+}
+
+func (cs *CookieStore) encode(name string, record *sessionRecord) (string, error) {
+	buf, err := encodeSession(record)
+	if err != nil {
+		return "", err
+	}
+	value, err := cs.codec.Encode(name, string(buf))
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxCookieSize {
+		return "", fmt.Errorf("session is too large for cookie: %d bytes", len(value))
+	}
+	return value, nil
+}
+
+func (cs *CookieStore) decode(name string, value string) (*sessionRecord, error) {
+	plain, err := cs.codec.Decode(name, value)
+	if err != nil {
+		return nil, err
+	}
+	record, err := decodeSession([]byte(plain))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(record.Expires) {
+		return nil, fmt.Errorf("session expired")
+	}
+	return record, nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const sessionFileSuffix = ".session"
+
+// sessionUsersDir is the directory of the index by user in the FileStore
+const sessionUsersDir = "users"
+
+// FileStore keep one file per session in Dir, the values are encoded with gob.
+// The types are registered when saved, the types only read must be declared
+// by NewKey or registered with gob.Register. The sessions of the users are
+// indexed by the empty files users/<hex of user id>/<session id>.
+type FileStore struct {
+	Dir string
+}
+
+// sessionRecord is the persisted form of a session
+type sessionRecord struct {
+	ID      string
+	Expires time.Time
+	Values  map[string]interface{}
+}
+
+// NewFileStore create a FileStore, dir will be created if not exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+func (f *FileStore) file(id string) (string, error) {
+	if id == "" || strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) >= 0 {
+		return "", fmt.Errorf("invalid session id")
+	}
+	return filepath.Join(f.Dir, id+sessionFileSuffix), nil
+}
+
+// Load read the session file, the expired file is removed
+func (f *FileStore) Load(id string) (map[string]interface{}, error) {
+	name, err := f.file(id)
+	if err != nil {
+		return nil, err
+	}
+	record, err := readSessionFile(name)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	if time.Now().After(record.Expires) {
+		return nil, f.remove(name, record)
+	}
+	return record.Values, nil
+}
+
+// Save write the session file atomically and update the index of the user
+func (f *FileStore) Save(id string, values map[string]interface{}, ttl time.Duration) error {
+	name, err := f.file(id)
+	if err != nil {
+		return err
+	}
+	buf, err := encodeSession(&sessionRecord{ID: id, Expires: time.Now().Add(ttl), Values: values})
+	if err != nil {
+		return err
+	}
+	old, _ := readSessionFile(name)
+	user := sessionUserID(values)
+	if user != "" {
+		if err := f.index(id, user); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(f.Dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if old != nil && sessionUserID(old.Values) != user {
+		return f.unindex(id, sessionUserID(old.Values))
+	}
+	return nil
+}
+
+// Delete remove the session file and its index
+func (f *FileStore) Delete(id string) error {
+	name, err := f.file(id)
+	if err != nil {
+		return err
+	}
+	record, _ := readSessionFile(name)
+	return f.remove(name, record)
+}
+
+// GC remove all the expired or broken session files, and the empty
+// directories of the index
+func (f *FileStore) GC() error {
+	files, err := filepath.Glob(filepath.Join(f.Dir, "*"+sessionFileSuffix))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, name := range files {
+		record, err := readSessionFile(name)
+		if err != nil || (record != nil && now.After(record.Expires)) {
+			if err := f.remove(name, record); err != nil {
+				return err
+			}
+		}
+	}
+	dirs, _ := os.ReadDir(filepath.Join(f.Dir, sessionUsersDir))
+	for _, dir := range dirs {
+		// fail if not empty
+		os.Remove(filepath.Join(f.Dir, sessionUsersDir, dir.Name()))
+	}
+	return nil
+}
+
+// List read the session files, the expired or broken files are skipped. The
+// sessions of a user are found by the index.
+func (f *FileStore) List(filter SessionFilter) ([]SessionInfo, error) {
+	if filter.UserID != "" {
+		return f.listUser(filter.UserID)
+	}
+	files, err := filepath.Glob(filepath.Join(f.Dir, "*"+sessionFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ret := []SessionInfo{}
+	for _, name := range files {
+		record, err := readSessionFile(name)
+		if err != nil || record == nil || now.After(record.Expires) {
+			continue
+		}
+		info := newSessionInfo(record.ID, record.Expires, record.Values)
+		if filter.match(&info) {
+			ret = append(ret, info)
+		}
+	}
+	sortSessions(ret)
+	return ret, nil
+}
+
+// listUser read the session files indexed for the user, the stale entries
+// of the index are removed
+func (f *FileStore) listUser(user string) ([]SessionInfo, error) {
+	entries, err := os.ReadDir(f.userDir(user))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	now := time.Now()
+	ret := []SessionInfo{}
+	for _, entry := range entries {
+		id := entry.Name()
+		name, err := f.file(id)
+		if err != nil {
+			continue
+		}
+		record, err := readSessionFile(name)
+		if err != nil {
+			continue
+		}
+		if record == nil || sessionUserID(record.Values) != user {
+			f.unindex(id, user)
+			continue
+		}
+		if now.After(record.Expires) {
+			continue
+		}
+		ret = append(ret, newSessionInfo(record.ID, record.Expires, record.Values))
+	}
+	sortSessions(ret)
+	return ret, nil
+}
+
+// userDir return the directory of the index of the user
+func (f *FileStore) userDir(user string) string {
+	return filepath.Join(f.Dir, sessionUsersDir, hex.EncodeToString([]byte(user)))
+}
+
+// index add the session to the index of the user, the directory is created
+// again if removed by GC meanwhile
+func (f *FileStore) index(id, user string) error {
+	name := filepath.Join(f.userDir(user), id)
+	var err error
+	for i := 0; i < 2; i++ {
+		if err = os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			return err
+		}
+		var file *os.File
+		if file, err = os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0600); err == nil {
+			return file.Close()
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// unindex remove the session from the index of the user
+func (f *FileStore) unindex(id, user string) error {
+	if user == "" {
+		return nil
+	}
+	return removeFile(filepath.Join(f.userDir(user), id))
+}
+
+// remove delete the session file and its index
+func (f *FileStore) remove(name string, record *sessionRecord) error {
+	if err := removeFile(name); err != nil {
+		return err
+	}
+	if record == nil {
+		return nil
+	}
+	return f.unindex(record.ID, sessionUserID(record.Values))
+}
+
+// Revoke remove the session file
+func (f *FileStore) Revoke(id string) error {
+	return f.Delete(id)
+}
+
+func readSessionFile(name string) (*sessionRecord, error) {
+	buf, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(buf)
+}
+
+func removeFile(name string) error {
+	err := os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func encodeSession(record *sessionRecord) ([]byte, error) {
+	if err := registerValues(record.Values); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSession(buf []byte) (*sessionRecord, error) {
+	var record sessionRecord
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&record); err != nil {
+		return nil, err
+	}
+	if record.Values == nil {
+		record.Values = map[string]interface{}{}
+	}
+	return &record, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Key is a typed session key, the name is prefixed with the namespace so the
+// keys of different packages can't collide
+type Key[T any] struct {
+	name string
+}
+
+// NewKey create a typed key, T is registered to gob so it can be saved by
+// the persistent stores
+func NewKey[T any](namespace, name string) Key[T] {
+	var zero T
+	registerType(zero)
+	return Key[T]{name: namespace + "." + name}
+}
+
+// Name return the name stored in the session
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// Get return the value of the key, false if not exist or not a T
+func (k Key[T]) Get(s *Session) (T, bool) {
+	return SessionValue[T](s, k.name)
+}
+
+// Set store the value of the key
+func (k Key[T]) Set(s *Session, v T) {
+	s.Set(k.name, v)
+}
+
+// Delete remove the value of the key
+func (k Key[T]) Delete(s *Session) {
+	s.Delete(k.name)
+}
+
+// SessionValue return the value stored in s as T, false if s is nil, the
+// value not exist or is not a T
+func SessionValue[T any](s *Session, key string) (T, bool) {
+	var zero T
+	if s == nil {
+		return zero, false
+	}
+	v, ok := s.Get(key).(T)
+	if !ok {
+		return zero, false
+	}
+	return v, true
+}
+
+// MustSessionValue return the value stored in s as T, it panics if the value
+// not exist or is not a T
+func MustSessionValue[T any](s *Session, key string) T {
+	v, ok := SessionValue[T](s, key)
+	if !ok {
+		var zero T
+		panic(fmt.Errorf("session value %s is not %T", key, zero))
+	}
+	return v
+}
+
+var registeredTypes sync.Map
+
+// registerType register the type of v to gob once, nil and the interface
+// types are ignored
+func registerType(v interface{}) (err error) {
+	if v == nil {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	if _, ok := registeredTypes.Load(t); ok {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("register session type %v: %v", t, r)
+		}
+	}()
+	gob.Register(v)
+	registeredTypes.Store(t, true)
+	return nil
+}
+
+// registerValues register the types of all the values before they are encoded
+func registerValues(values map[string]interface{}) error {
+	for _, v := range values {
+		if err := registerType(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
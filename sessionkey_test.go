@@ -0,0 +1,95 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testProfile struct {
+	Name  string
+	Roles []string
+}
+
+type testCart struct {
+	Items int
+}
+
+func TestSessionKey(t *testing.T) {
+	profile := NewKey[testProfile]("users", "profile")
+	other := NewKey[string]("shop", "profile")
+	assert.Equal(t, "users.profile", profile.Name())
+
+	s := newSession()
+	_, ok := profile.Get(s)
+	assert.False(t, ok)
+	profile.Set(s, testProfile{Name: "tom"})
+	other.Set(s, "other")
+	p, ok := profile.Get(s)
+	assert.True(t, ok)
+	assert.Equal(t, "tom", p.Name)
+	v, _ := other.Get(s)
+	assert.Equal(t, "other", v)
+	profile.Delete(s)
+	_, ok = profile.Get(s)
+	assert.False(t, ok)
+
+	s.Set("count", 3)
+	n, ok := SessionValue[int](s, "count")
+	assert.True(t, ok)
+	assert.Equal(t, 3, n)
+	_, ok = SessionValue[string](s, "count")
+	assert.False(t, ok)
+	_, ok = SessionValue[int](nil, "count")
+	assert.False(t, ok)
+	assert.Equal(t, 3, MustSessionValue[int](s, "count"))
+	assert.Panics(t, func() { MustSessionValue[string](s, "count") })
+}
+
+func TestSessionValueSaved(t *testing.T) {
+	for name, store := range map[string]SessionStore{
+		"file":   mustFileStore(t),
+		"cookie": NewCookieStore("test secret"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := sessionEngine(t, store)
+			engine.GET("/cart", func(c *gin.Context) {
+				FromGin(c).Set("cart", testCart{Items: 2})
+				c.String(200, "ok")
+			})
+			engine.GET("/items", func(c *gin.Context) {
+				cart := MustSessionValue[testCart](FromGin(c), "cart")
+				c.JSON(200, cart.Items)
+			})
+			w := doRequest(engine, "GET", "/cart")
+			cookie := sessionCookie(w)
+			if !assert.NotNil(t, cookie) {
+				return
+			}
+			w = doRequest(engine, "GET", "/items", cookie)
+			assert.Equal(t, "2", w.Body.String())
+		})
+	}
+}
+
+func TestSessionConfig(t *testing.T) {
+	config := initConfig()
+	engine := gin.New()
+	engine.Use(UseSession(config))
+	engine.GET("/", func(c *gin.Context) {
+		assert.Same(t, config, FromGin(c).Config())
+		assert.Same(t, config, SessionConfig())
+	})
+	doRequest(engine, "GET", "/")
+}
+
+func mustFileStore(t *testing.T) SessionStore {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	return store
+}
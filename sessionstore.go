@@ -0,0 +1,540 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// SessionStore persist the values of the sessions by session id.
+// Load return nil values without error when the session is not found or expired.
+type SessionStore interface {
+	Load(id string) (map[string]interface{}, error)
+	Save(id string, values map[string]interface{}, ttl time.Duration) error
+	Delete(id string) error
+}
+
+// SessionOptions is the configuration of the session cookie
+type SessionOptions struct {
+	CookieName string
+	Domain     string
+	Path       string
+	// MaxAge is the seconds of the cookie and the session lifetime, 0 means
+	// a browser session cookie which is kept in the store for one day
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+	// Secret is used to encrypt and authenticate the session id in the cookie
+	Secret string
+	// OldSecrets are still accepted to decrypt the cookie for key rotation
+	OldSecrets []string
+}
+
+// DefaultSessionOptions return the options used for the missing configuration
+func DefaultSessionOptions() SessionOptions {
+	return SessionOptions{
+		CookieName: "gintool_session",
+		Path:       "/",
+		MaxAge:     86400,
+		HttpOnly:   true,
+		SameSite:   http.SameSiteLaxMode,
+	}
+}
+
+func (o *SessionOptions) ttl() time.Duration {
+	if o.MaxAge <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(o.MaxAge) * time.Second
+}
+
+type sessionManager struct {
+	options    SessionOptions
+	store      SessionStore
+	codec      *cookieCodec
+	gcInterval time.Duration
+}
+
+// sessionCollector is implemented by the stores need to remove the expired sessions
+type sessionCollector interface {
+	GC() error
+}
+
+func newSessionManager(options SessionOptions, store SessionStore) (*sessionManager, error) {
+	if options.Secret == "" {
+		return nil, fmt.Errorf("session secret is required")
+	}
+	return &sessionManager{
+		options: options,
+		store:   store,
+		codec:   newCookieCodec(append([]string{options.Secret}, options.OldSecrets...)...),
+	}, nil
+}
+
+// startGC remove the expired sessions of the store every gcInterval, the
+// returned function stop it
+func (m *sessionManager) startGC(config *Config) func() {
+	collector, ok := m.store.(sessionCollector)
+	if !ok || m.gcInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(m.gcInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := collector.GC(); err != nil {
+					config.errlog.Error().Msgf("[Session] gc: %v", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// SetSessionStore replace the SessionStore of the session configured in gin.conf
+func (ge *GinEngine) SetSessionStore(store SessionStore) error {
+	if ge.config.session == nil {
+		return fmt.Errorf("session is not configured")
+	}
+	ge.config.session.store = store
+	return nil
+}
+
+func (m *sessionManager) load(c *gin.Context, s *Session) {
+	s.mu.Lock()
+	s.manager = m
+	s.ctx = c
+	s.mu.Unlock()
+	if cookie, err := c.Request.Cookie(m.options.CookieName); err == nil {
+		if cs, ok := m.store.(*CookieStore); ok {
+			if record, err := cs.decode(m.options.CookieName, cookie.Value); err == nil {
+				s.mu.Lock()
+				s.id = record.ID
+				s.data = record.Values
+				s.mu.Unlock()
+				return
+			}
+		}
+		id, err := m.codec.Decode(m.options.CookieName, cookie.Value)
+		if err == nil {
+			values, err := m.store.Load(id)
+			if err != nil {
+				s.configOrDefault().errlog.Error().Msgf("[Session] load %s: %v", c.Request.URL.Path, err)
+			}
+			if values != nil {
+				s.mu.Lock()
+				s.id = id
+				s.data = values
+				s.mu.Unlock()
+				return
+			}
+		}
+	}
+	s.mu.Lock()
+	s.id = newSessionID()
+	s.isNew = true
+	s.mu.Unlock()
+}
+
+// commit save the modified session, or expire the cookie of the destroyed session
+func (m *sessionManager) commit(s *Session) {
+	s.mu.RLock()
+	modified, destroyed, isNew := s.modified, s.destroyed, s.isNew
+	s.mu.RUnlock()
+	if modified && !(isNew && len(s.Keys()) == 0) {
+		if err := m.save(s); err != nil {
+			s.configOrDefault().errlog.Error().Msgf("[Session] save %s: %v", s.path(), err)
+		}
+		return
+	}
+	if destroyed {
+		m.setCookie(s, "", -1)
+		s.mu.Lock()
+		s.destroyed = false
+		s.mu.Unlock()
+	}
+}
+
+func (m *sessionManager) save(s *Session) error {
+	s.mu.Lock()
+	s.modified = false
+	s.mu.Unlock()
+	value, err := m.encode(s)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	isNew := s.isNew
+	s.mu.Unlock()
+	if !m.setCookie(s, value, m.options.MaxAge) && isNew {
+		s.configOrDefault().stdlog.Warn().Msgf("[Session] new session saved after response written: %s", s.path())
+	}
+	s.mu.Lock()
+	s.isNew = false
+	s.destroyed = false
+	s.mu.Unlock()
+	return nil
+}
+
+// encode save the session to the store and return the cookie value
+func (m *sessionManager) encode(s *Session) (string, error) {
+	if cs, ok := m.store.(*CookieStore); ok {
+		return cs.encode(m.options.CookieName, &sessionRecord{
+			ID:      s.ID(),
+			Expires: time.Now().Add(m.options.ttl()),
+			Values:  s.values(),
+		})
+	}
+	if err := m.store.Save(s.ID(), s.values(), m.options.ttl()); err != nil {
+		return "", err
+	}
+	return m.codec.Encode(m.options.CookieName, s.ID())
+}
+
+func (m *sessionManager) destroy(s *Session) error {
+	err := m.store.Delete(s.ID())
+	s.mu.Lock()
+	s.data = map[string]interface{}{}
+	s.id = newSessionID()
+	s.isNew = true
+	s.modified = false
+	s.destroyed = true
+	s.mu.Unlock()
+	m.commit(s)
+	return err
+}
+
+func (m *sessionManager) regenerate(s *Session) error {
+	err := m.store.Delete(s.ID())
+	s.mu.Lock()
+	s.id = newSessionID()
+	s.modified = true
+	s.mu.Unlock()
+	return err
+}
+
+// setCookie replace the session cookie of the response, return false if the
+// header is already written or the session is detached
+func (m *sessionManager) setCookie(s *Session, value string, maxAge int) bool {
+	c := s.context()
+	if c == nil || c.Writer.Written() {
+		return false
+	}
+	w := c.Writer
+	header := w.Header()
+	prefix := m.options.CookieName + "="
+	cookies := header.Values("Set-Cookie")
+	header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		if !strings.HasPrefix(cookie, prefix) {
+			header.Add("Set-Cookie", cookie)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.options.CookieName,
+		Value:    value,
+		Path:     m.options.Path,
+		Domain:   m.options.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.options.Secure,
+		HttpOnly: m.options.HttpOnly,
+		SameSite: m.options.SameSite,
+	})
+	return true
+}
+
+// path return the path of the request for the logs
+func (s *Session) path() string {
+	if c := s.context(); c != nil {
+		return c.Request.URL.Path
+	}
+	return ""
+}
+
+func (s *Session) configOrDefault() *Config {
+	if c := s.Config(); c != nil {
+		return c
+	}
+	return initConfig()
+}
+
+func newSessionID() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// DefaultMemoryStoreSize is the max sessions kept by the MemoryStore by default
+const DefaultMemoryStoreSize = 10000
+
+// MemoryStore keep the sessions in memory, they are lost when the app restart.
+// The expired sessions are evicted by GC, and the least recently used sessions
+// are evicted when the size exceed the max size.
+type MemoryStore struct {
+	sessions *lru.Cache
+	// users index the session ids by user id, it is always locked after
+	// the lru cache which call onEvict with its lock held
+	mu    sync.Mutex
+	users map[string]map[string]struct{}
+}
+
+type memoryEntry struct {
+	values  map[string]interface{}
+	expires time.Time
+}
+
+// NewMemoryStore create a MemoryStore keep maxSize sessions at most,
+// DefaultMemoryStoreSize is used if maxSize <= 0
+func NewMemoryStore(maxSize int) *MemoryStore {
+	if maxSize <= 0 {
+		maxSize = DefaultMemoryStoreSize
+	}
+	m := &MemoryStore{users: map[string]map[string]struct{}{}}
+	m.sessions, _ = lru.NewWithEvict(maxSize, m.onEvict)
+	return m
+}
+
+// Load return a copy of the session values
+func (m *MemoryStore) Load(id string) (map[string]interface{}, error) {
+	v, ok := m.sessions.Get(id)
+	if !ok {
+		return nil, nil
+	}
+	entry := v.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		m.sessions.Remove(id)
+		return nil, nil
+	}
+	return copyValues(entry.values), nil
+}
+
+// Save store a copy of the session values
+func (m *MemoryStore) Save(id string, values map[string]interface{}, ttl time.Duration) error {
+	old, _ := m.sessions.Peek(id)
+	entry := &memoryEntry{values: copyValues(values), expires: time.Now().Add(ttl)}
+	m.sessions.Add(id, entry)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old != nil {
+		m.unindex(id, old.(*memoryEntry))
+	}
+	if user := sessionUserID(entry.values); user != "" {
+		if m.users[user] == nil {
+			m.users[user] = map[string]struct{}{}
+		}
+		m.users[user][id] = struct{}{}
+	}
+	return nil
+}
+
+func (m *MemoryStore) onEvict(key interface{}, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unindex(key.(string), value.(*memoryEntry))
+}
+
+func (m *MemoryStore) unindex(id string, entry *memoryEntry) {
+	user := sessionUserID(entry.values)
+	if ids := m.users[user]; ids != nil {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(m.users, user)
+		}
+	}
+}
+
+// List return the sessions not expired, the sessions of a user are found by the index
+func (m *MemoryStore) List(filter SessionFilter) ([]SessionInfo, error) {
+	var ids []string
+	if filter.UserID != "" {
+		m.mu.Lock()
+		for id := range m.users[filter.UserID] {
+			ids = append(ids, id)
+		}
+		m.mu.Unlock()
+	} else {
+		for _, key := range m.sessions.Keys() {
+			ids = append(ids, key.(string))
+		}
+	}
+	now := time.Now()
+	ret := []SessionInfo{}
+	for _, id := range ids {
+		v, ok := m.sessions.Peek(id)
+		if !ok {
+			continue
+		}
+		entry := v.(*memoryEntry)
+		info := newSessionInfo(id, entry.expires, entry.values)
+		if now.Before(entry.expires) && filter.match(&info) {
+			ret = append(ret, info)
+		}
+	}
+	sortSessions(ret)
+	return ret, nil
+}
+
+// Revoke remove the session
+func (m *MemoryStore) Revoke(id string) error {
+	return m.Delete(id)
+}
+
+// Delete remove the session
+func (m *MemoryStore) Delete(id string) error {
+	m.sessions.Remove(id)
+	return nil
+}
+
+// Len return the count of the sessions in the store
+func (m *MemoryStore) Len() int {
+	return m.sessions.Len()
+}
+
+// GC remove all the expired sessions
+func (m *MemoryStore) GC() error {
+	now := time.Now()
+	for _, id := range m.sessions.Keys() {
+		if v, ok := m.sessions.Peek(id); ok && now.After(v.(*memoryEntry).expires) {
+			m.sessions.Remove(id)
+		}
+	}
+	return nil
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		ret[k] = v
+	}
+	return ret
+}
+
+func parseSessionOptions(m interface{}) (SessionOptions, error) {
+	o := DefaultSessionOptions()
+	for name, value := range map[string]*string{
+		"cookie": &o.CookieName,
+		"domain": &o.Domain,
+		"path":   &o.Path,
+		"secret": &o.Secret,
+	} {
+		mm, err := extract(m, name)
+		if err != nil {
+			continue
+		}
+		ss, ok := mm.(string)
+		if !ok {
+			return o, fmt.Errorf("wrong type session %s", name)
+		}
+		*value = ss
+	}
+	for name, value := range map[string]*bool{
+		"secure":   &o.Secure,
+		"httponly": &o.HttpOnly,
+	} {
+		mm, err := extract(m, name)
+		if err != nil {
+			continue
+		}
+		b, ok := mm.(bool)
+		if !ok {
+			return o, fmt.Errorf("wrong type session %s", name)
+		}
+		*value = b
+	}
+	if mm, err := extract(m, "maxage"); err == nil {
+		age, ok := mm.(int)
+		if !ok {
+			return o, fmt.Errorf("wrong type session maxage")
+		}
+		o.MaxAge = age
+	}
+	if mm, err := extract(m, "samesite"); err == nil {
+		switch strings.ToLower(fmt.Sprint(mm)) {
+		case "lax":
+			o.SameSite = http.SameSiteLaxMode
+		case "strict":
+			o.SameSite = http.SameSiteStrictMode
+		case "none":
+			o.SameSite = http.SameSiteNoneMode
+		case "default":
+			o.SameSite = http.SameSiteDefaultMode
+		default:
+			return o, fmt.Errorf("wrong session samesite %v", mm)
+		}
+	}
+	if mm, err := extract(m, "oldsecrets"); err == nil {
+		list, err := stringList(mm)
+		if err != nil {
+			return o, err
+		}
+		o.OldSecrets = list
+	}
+	if o.Secret == "" {
+		return o, fmt.Errorf("session secret is required")
+	}
+	return o, nil
+}
+
+// parseSessionStore create the store selected by session.store, memory by default
+func parseSessionStore(m interface{}, o SessionOptions) (SessionStore, error) {
+	name := "memory"
+	if mm, err := extract(m, "store"); err == nil {
+		name = fmt.Sprint(mm)
+	}
+	switch name {
+	case "memory":
+		size := 0
+		if mm, err := extract(m, "maxsize"); err == nil {
+			var ok bool
+			if size, ok = mm.(int); !ok {
+				return nil, fmt.Errorf("wrong type session maxsize")
+			}
+		}
+		return NewMemoryStore(size), nil
+	case "file":
+		mm, err := extract(m, "dir")
+		if err != nil {
+			return nil, fmt.Errorf("session dir is required by file store")
+		}
+		dir, ok := mm.(string)
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("wrong type session dir")
+		}
+		return NewFileStore(dir)
+	case "cookie":
+		return NewCookieStore(o.Secret, o.OldSecrets...), nil
+	}
+	return nil, fmt.Errorf("unknown session store %s", name)
+}
+
+// parseGCInterval return the interval of session.gc in seconds, 10 minutes by default
+func parseGCInterval(m interface{}) (time.Duration, error) {
+	mm, err := extract(m, "gc")
+	if err != nil {
+		return 10 * time.Minute, nil
+	}
+	seconds, ok := mm.(int)
+	if !ok {
+		return 0, fmt.Errorf("wrong type session gc")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func sessionEngine(t *testing.T, store SessionStore) *gin.Engine {
+	config := initConfig()
+	o := DefaultSessionOptions()
+	o.Secret = "test secret"
+	o.Secure = true
+	o.Domain = "example.com"
+	m, err := newSessionManager(o, store)
+	assert.Nil(t, err)
+	config.session = m
+	engine := gin.New()
+	engine.Use(UseSession(config))
+	engine.GET("/set", func(c *gin.Context) {
+		SessionSet("user", c.Query("user"))
+		c.String(200, "ok")
+	})
+	engine.GET("/get", func(c *gin.Context) {
+		v, _ := SessionGet("user").(string)
+		c.String(200, v)
+	})
+	engine.GET("/regenerate", func(c *gin.Context) {
+		assert.Nil(t, GetSession().RegenerateID())
+		c.String(200, "ok")
+	})
+	engine.GET("/destroy", func(c *gin.Context) {
+		assert.Nil(t, GetSession().Destroy())
+		c.String(200, "ok")
+	})
+	engine.GET("/late", func(c *gin.Context) {
+		c.String(200, "ok")
+		SessionSet("late", true)
+	})
+	engine.POST("/redirect", func(c *gin.Context) {
+		SessionSet("user", "posted")
+		c.Redirect(http.StatusSeeOther, "/get")
+	})
+	return engine
+}
+
+func doRequest(engine *gin.Engine, method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "gintool_session" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestSessionStoreConformance(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	stores := []struct {
+		name      string
+		store     SessionStore
+		stateless bool
+	}{
+		{"memory", NewMemoryStore(0), false},
+		{"file", fileStore, false},
+		{"cookie", NewCookieStore("cookie secret"), true},
+	}
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionConformance(t, tt.store, tt.stateless)
+		})
+	}
+}
+
+// sessionConformance is the behavior shared by all the SessionStore, the
+// stateless store can't revoke the old cookie
+func sessionConformance(t *testing.T, store SessionStore, stateless bool) {
+	engine := sessionEngine(t, store)
+
+	w := doRequest(engine, "GET", "/get")
+	assert.Nil(t, sessionCookie(w), "empty session should not set cookie")
+
+	w = doRequest(engine, "GET", "/set?user=plain-user-value")
+	cookie := sessionCookie(w)
+	if !assert.NotNil(t, cookie) {
+		return
+	}
+	assert.Len(t, w.Result().Header.Values("Set-Cookie"), 1)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, "/", cookie.Path)
+	assert.Equal(t, "example.com", cookie.Domain)
+	assert.Equal(t, 86400, cookie.MaxAge)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.NotContains(t, cookie.Value, "plain-user-value")
+
+	w = doRequest(engine, "GET", "/get", cookie)
+	assert.Equal(t, "plain-user-value", w.Body.String())
+	assert.Nil(t, sessionCookie(w), "unmodified session should not set cookie")
+
+	forged := *cookie
+	forged.Value = cookie.Value[:len(cookie.Value)-2] + "AA"
+	w = doRequest(engine, "GET", "/get", &forged)
+	assert.Equal(t, "", w.Body.String())
+
+	w = doRequest(engine, "GET", "/regenerate", cookie)
+	regenerated := sessionCookie(w)
+	assert.NotNil(t, regenerated)
+	assert.NotEqual(t, cookie.Value, regenerated.Value)
+	if !stateless {
+		assert.Equal(t, "", doRequest(engine, "GET", "/get", cookie).Body.String(), "old id must be invalid")
+	}
+	assert.Equal(t, "plain-user-value", doRequest(engine, "GET", "/get", regenerated).Body.String())
+
+	w = doRequest(engine, "GET", "/destroy", regenerated)
+	expired := sessionCookie(w)
+	assert.NotNil(t, expired)
+	assert.True(t, expired.MaxAge < 0)
+	if !stateless {
+		assert.Equal(t, "", doRequest(engine, "GET", "/get", regenerated).Body.String())
+	}
+
+	w = doRequest(engine, "POST", "/redirect")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "posted", doRequest(engine, "GET", "/get", sessionCookie(w)).Body.String())
+
+	w = doRequest(engine, "GET", "/late")
+	assert.Nil(t, sessionCookie(w), "cookie can't be set after the body is written")
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(2)
+	values := map[string]interface{}{"a": 1}
+	assert.Nil(t, store.Save("1", values, time.Hour))
+	values["a"] = 2
+	v, err := store.Load("1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1}, v, "store keep a copy")
+
+	assert.Nil(t, store.Save("2", values, time.Hour))
+	_, _ = store.Load("1")
+	assert.Nil(t, store.Save("3", values, time.Hour))
+	assert.Equal(t, 2, store.Len())
+	v, _ = store.Load("2")
+	assert.Nil(t, v, "least recently used session is evicted")
+
+	assert.Nil(t, store.Save("4", values, -time.Second))
+	v, _ = store.Load("4")
+	assert.Nil(t, v, "expired session")
+	assert.Nil(t, store.Save("4", values, -time.Second))
+	assert.Nil(t, store.GC())
+	assert.Equal(t, 1, store.Len())
+	assert.Nil(t, store.Delete("3"))
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save("a1", map[string]interface{}{"n": 1, "s": "x"}, time.Hour))
+	assert.Nil(t, store.Save("a2", map[string]interface{}{}, -time.Second))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "broken"+sessionFileSuffix), []byte("broken"), 0600))
+	v, err := store.Load("a1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"n": 1, "s": "x"}, v)
+	v, err = store.Load("missing")
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+	_, err = store.Load("../a1")
+	assert.NotNil(t, err)
+	assert.NotNil(t, store.Save("", nil, time.Hour))
+
+	assert.Nil(t, store.GC())
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{filepath.Join(dir, "a1"+sessionFileSuffix)}, files)
+	assert.Nil(t, store.Delete("a1"))
+	assert.Nil(t, store.Delete("a1"))
+}
+
+func TestCookieStore(t *testing.T) {
+	old := NewCookieStore("old")
+	record := &sessionRecord{ID: "id", Expires: time.Now().Add(time.Hour), Values: map[string]interface{}{"a": "b"}}
+	value, err := old.encode("sid", record)
+	assert.Nil(t, err)
+
+	rotated := NewCookieStore("new", "old")
+	got, err := rotated.decode("sid", value)
+	assert.Nil(t, err, "old key is still accepted")
+	assert.Equal(t, record.Values, got.Values)
+	value, err = rotated.encode("sid", record)
+	assert.Nil(t, err)
+	_, err = old.decode("sid", value)
+	assert.NotNil(t, err, "encoded by the new key")
+	_, err = NewCookieStore("new").decode("sid", value)
+	assert.Nil(t, err)
+
+	record.Expires = time.Now().Add(-time.Second)
+	value, _ = rotated.encode("sid", record)
+	_, err = rotated.decode("sid", value)
+	assert.NotNil(t, err, "expired")
+
+	record.Values["large"] = strings.Repeat("x", maxCookieSize)
+	_, err = rotated.encode("sid", record)
+	assert.NotNil(t, err, "too large")
+}
+
+func TestParseSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		conf    string
+		want    interface{}
+		wantErr bool
+	}{
+		{"secret: s", &MemoryStore{}, false},
+		{"secret: s\nstore: memory\nmaxsize: 10", &MemoryStore{}, false},
+		{"secret: s\nstore: file\ndir: " + dir, &FileStore{}, false},
+		{"secret: s\nstore: cookie\noldsecrets: [a, b]", &CookieStore{}, false},
+		{"secret: s\nstore: memory\nmaxsize: big", nil, true},
+		{"secret: s\nstore: file", nil, true},
+		{"secret: s\nstore: redis", nil, true},
+	}
+	for _, tt := range tests {
+		var out interface{}
+		assert.Nil(t, yaml.Unmarshal([]byte(tt.conf), &out))
+		o, err := parseSessionOptions(out)
+		assert.Nil(t, err)
+		store, err := parseSessionStore(out, o)
+		assert.Equal(t, tt.wantErr, err != nil, tt.conf)
+		if !tt.wantErr {
+			assert.IsType(t, tt.want, store, tt.conf)
+		}
+	}
+	var out interface{}
+	assert.Nil(t, yaml.Unmarshal([]byte("gc: 60"), &out))
+	d, err := parseGCInterval(out)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, d)
+}
+
+func TestSessionWithoutStore(t *testing.T) {
+	config := initConfig()
+	engine := gin.New()
+	engine.Use(UseSession(config))
+	engine.GET("/", func(c *gin.Context) {
+		s := GetSession()
+		s.Set("a", 1)
+		assert.Equal(t, "", s.ID())
+		assert.Nil(t, s.Save())
+		assert.Nil(t, s.RegenerateID())
+		assert.Nil(t, s.Destroy())
+		assert.Equal(t, 1, s.Get("a"))
+		c.String(200, "ok")
+	})
+	w := doRequest(engine, "GET", "/")
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Empty(t, w.Result().Cookies())
+}
+
+func TestParseSessionOptions(t *testing.T) {
+	var out interface{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+cookie: sid
+domain: example.com
+path: /app
+maxage: 3600
+secure: true
+httponly: false
+samesite: strict
+secret: s3cret
+`), &out))
+	o, err := parseSessionOptions(out)
+	assert.Nil(t, err)
+	assert.Equal(t, SessionOptions{
+		CookieName: "sid",
+		Domain:     "example.com",
+		Path:       "/app",
+		MaxAge:     3600,
+		Secure:     true,
+		HttpOnly:   false,
+		SameSite:   http.SameSiteStrictMode,
+		Secret:     "s3cret",
+	}, o)
+
+	for _, conf := range []string{"cookie: sid", "secret: s\nsamesite: wrong", "secret: s\nmaxage: long", "secret: s\nsecure: yes please"} {
+		assert.Nil(t, yaml.Unmarshal([]byte(conf), &out))
+		_, err = parseSessionOptions(out)
+		assert.NotNil(t, err, conf)
+	}
+}
+
+func TestCookieCodec(t *testing.T) {
+	codec := newCookieCodec("secret")
+	value, err := codec.Encode("a", "id")
+	assert.Nil(t, err)
+	ret, err := codec.Decode("a", value)
+	assert.Nil(t, err)
+	assert.Equal(t, "id", ret)
+	_, err = codec.Decode("b", value)
+	assert.NotNil(t, err, "value can't be used by another cookie")
+	_, err = newCookieCodec("other").Decode("a", value)
+	assert.NotNil(t, err)
+	_, err = codec.Decode("a", "!!")
+	assert.NotNil(t, err)
+	_, err = codec.Decode("a", "AAAA")
+	assert.NotNil(t, err)
+}
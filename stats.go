@@ -0,0 +1,28 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import "sync/atomic"
+
+// Stats is the counters of the recovered requests
+type Stats struct {
+	// Panics is the count of panics answered with the error response
+	Panics uint64
+	// ClientGone is the count of requests aborted because the client closed the connection
+	ClientGone uint64
+}
+
+type stats struct {
+	panics     atomic.Uint64
+	clientGone atomic.Uint64
+}
+
+// Stats return the current counters of the engine
+func (ge *GinEngine) Stats() Stats {
+	return Stats{
+		Panics:     ge.config.stats.panics.Load(),
+		ClientGone: ge.config.stats.clientGone.Load(),
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func TestTemplateErrors(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(gin.DebugMode)
+	config := initConfig()
+	config.errors[500] = "error/500.html"
+	render := plushgin.Default()
+	render.Options.TemplateDir = "testdata/templates"
+	render.AddHelper("boom", func() (string, error) {
+		return "", errors.New("kaboom")
+	})
+	engine := gin.New()
+	engine.HTMLRender = render
+	engine.Use(ginRecovery(config))
+	var renderErr error
+	for _, name := range []string{"missing.html", "broken.html"} {
+		engine.GET("/"+name, func(c *gin.Context) {
+			c.HTML(200, c.Request.URL.Path[1:], gin.H{})
+			renderErr = c.Errors.Last().Err
+		})
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/missing.html")
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "Huston")
+	var notFound *plushgin.TemplateNotFoundError
+	if assert.True(t, errors.As(renderErr, &notFound)) {
+		assert.Equal(t, "missing.html", notFound.Name)
+		assert.Equal(t, "testdata/templates/missing.html", notFound.File)
+	}
+
+	w = get("/broken.html")
+	assert.Equal(t, 500, w.Code)
+	assert.NotContains(t, w.Body.String(), "partial output")
+	var execErr *plushgin.TemplateExecError
+	if assert.True(t, errors.As(renderErr, &execErr)) {
+		assert.Equal(t, "broken.html", execErr.Name)
+		assert.Equal(t, "testdata/templates/broken.html", execErr.File)
+		assert.Equal(t, 2, execErr.Line)
+		assert.Equal(t, "boom", execErr.Helper)
+		assert.Contains(t, execErr.Error(), "testdata/templates/broken.html:2 in boom: ")
+	}
+
+	// the broken error page fallback to text
+	config.errors[500] = "broken.html"
+	w = get("/missing.html")
+	assert.Equal(t, 500, w.Code)
+	assert.Equal(t, "500 Internal Server Error", w.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func TestTemplateFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":        {Data: []byte("embedded <%= name %>")},
+		"layouts/base.html": {Data: []byte("[<%= yield %>]")},
+	}
+	dir := t.TempDir()
+	render := plushgin.Default()
+	render.Options.TemplateDir = dir
+	ge := &GinEngine{Engine: gin.New(), template: render, config: initConfig()}
+	ge.Engine.HTMLRender = render
+	ge.Engine.Use(ginRecovery(ge.config))
+	ge.Engine.GET("/:name", func(c *gin.Context) {
+		c.HTML(200, c.Param("name")+".html", gin.H{"name": "tom", "layout": c.Query("layout")})
+	})
+	get := func(path string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/plain")
+		w := httptest.NewRecorder()
+		ge.Engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	ge.SetTemplateFS(fsys)
+	assert.Equal(t, "embedded tom", get("/index"))
+	assert.Equal(t, "[embedded tom]", get("/index?layout=layouts/base.html"))
+	assert.Contains(t, get("/missing"), "template missing.html not found: missing.html")
+
+	// the directory is ignored without overlay
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("disk <%= name %>"), 0600))
+	assert.Equal(t, "embedded tom", get("/index"))
+
+	ge.config.templateOverlay = true
+	ge.SetTemplateFS(fsys)
+	assert.Equal(t, "disk tom", get("/index"))
+	assert.Equal(t, "[disk tom]", get("/index?layout=layouts/base.html"))
+}
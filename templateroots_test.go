@@ -0,0 +1,67 @@
+// Copyright 2019 Cytown.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package gintool
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cytown/gintool/plushgin"
+)
+
+func TestTemplateRootsConfig(t *testing.T) {
+	app := t.TempDir()
+	shared := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(shared, "email"), 0700))
+	assert.Nil(t, os.WriteFile(filepath.Join(app, "index.html"), []byte("app <%= partialFeeder(\"x\") %>|"), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(app, "_x"), []byte("x"), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(shared, "index.html"), []byte("shared"), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(shared, "email", "footer.html"), []byte("footer"), 0600))
+	conf := filepath.Join(t.TempDir(), "gin.conf")
+	write := func(templates string) {
+		assert.Nil(t, os.WriteFile(conf, []byte("gin:\n  templates:\n"+templates), 0600))
+	}
+
+	write("    - " + app + "\n    - name: shared\n      path: " + shared + "\n")
+	c, err := parseFile(conf)
+	assert.Nil(t, err)
+	assert.Equal(t, []plushgin.TemplateRoot{{Dir: app}, {Name: "shared", Dir: shared}}, c.templateRoots)
+	assert.Equal(t, "", c.templates)
+
+	render := plushgin.Default()
+	render.Options.TemplateDir = ""
+	render.Options.Roots = c.templateRoots
+	engine := gin.New()
+	engine.HTMLRender = render
+	engine.GET("/*name", func(c *gin.Context) {
+		c.HTML(200, c.Param("name")[1:], nil)
+	})
+	for path, want := range map[string]string{
+		"/index.html":               "app x|",
+		"/shared:index.html":        "shared",
+		"/email/footer.html":        "footer",
+		"/shared:email/footer.html": "footer",
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, want, w.Body.String(), path)
+	}
+
+	for _, templates := range []string{
+		"    - " + filepath.Join(app, "missing") + "\n",
+		"    - name: shared\n      path: " + filepath.Join(app, "index.html") + "\n",
+		"    - name: a:b\n      path: " + shared + "\n",
+		"    - [" + app + "]\n",
+	} {
+		write(templates)
+		_, err = parseFile(conf)
+		assert.NotNil(t, err, templates)
+	}
+}
@@ -63,3 +63,19 @@ func isDir(path string) error {
 	}
 	return nil
 }
+
+func stringList(out interface{}) ([]string, error) {
+	ss, ok := out.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v is not a list", out)
+	}
+	ret := make([]string, 0, len(ss))
+	for _, s := range ss {
+		str, ok := s.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a string", s)
+		}
+		ret = append(ret, str)
+	}
+	return ret, nil
+}